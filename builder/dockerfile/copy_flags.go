@@ -0,0 +1,43 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/idtools"
+)
+
+// copyFlags is the subset of the --chown/--chmod flags shared by the ADD and
+// COPY instructions. Both flags are optional and, unlike --from, apply to
+// every source the instruction copies.
+type copyFlags struct {
+	chown string
+	chmod string
+}
+
+// resolveCopyOptions turns the raw --chown/--chmod flag values of an ADD or
+// COPY instruction into a copyFileOptions, resolving --chown against the
+// container rootfs rooted at ctrRootPath and its idMappings. Either flag may
+// be empty, in which case the corresponding override in the result is left
+// nil and performCopyForInfo/copyDirectory leave that aspect of the copy
+// untouched.
+func resolveCopyOptions(flags copyFlags, ctrRootPath string, idMappings *idtools.IDMappings) (copyFileOptions, error) {
+	var options copyFileOptions
+
+	if flags.chown != "" {
+		pair, err := parseChownFlag(flags.chown, ctrRootPath, idMappings)
+		if err != nil {
+			return copyFileOptions{}, fmt.Errorf("invalid chown flag: %w", err)
+		}
+		options.chownPair = &pair
+	}
+
+	if flags.chmod != "" {
+		mode, err := parseChmodFlag(flags.chmod)
+		if err != nil {
+			return copyFileOptions{}, fmt.Errorf("invalid chmod flag: %w", err)
+		}
+		options.chmod = &mode
+	}
+
+	return options, nil
+}
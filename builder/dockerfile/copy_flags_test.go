@@ -0,0 +1,39 @@
+package dockerfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCopyOptionsChownAndChmod(t *testing.T) {
+	contextDir, cleanup := createTestTempDir(t, "", "builder-chown-chmod-parse-test")
+	defer cleanup()
+
+	require.NoError(t, os.Mkdir(filepath.Join(contextDir, "etc"), 0755))
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "passwd", "root:x:0:0::/bin:/bin/false\n", 0644)
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "group", "root:x:0:\n", 0644)
+
+	options, err := resolveCopyOptions(copyFlags{chown: "root:root", chmod: "0640"}, contextDir, &idtools.IDMappings{})
+	require.NoError(t, err)
+	require.NotNil(t, options.chownPair)
+	assert.Equal(t, idtools.IDPair{UID: 0, GID: 0}, *options.chownPair)
+	require.NotNil(t, options.chmod)
+	assert.Equal(t, os.FileMode(0640), *options.chmod)
+}
+
+func TestResolveCopyOptionsEmptyFlagsAreNoop(t *testing.T) {
+	options, err := resolveCopyOptions(copyFlags{}, "/ctx", &idtools.IDMappings{})
+	require.NoError(t, err)
+	assert.Nil(t, options.chownPair)
+	assert.Nil(t, options.chmod)
+}
+
+func TestResolveCopyOptionsInvalidChownFails(t *testing.T) {
+	_, err := resolveCopyOptions(copyFlags{chown: "bob"}, "/ctx", &idtools.IDMappings{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+	"path/filepath"
+
+	mounttypes "github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/idtools"
+)
+
+// copyRequest carries everything dispatchCopy/dispatchAdd need to resolve
+// and execute a single ADD/COPY instruction: the raw --chown/--chmod flag
+// text straight off the instruction, the container rootfs plus id mapping
+// required to turn --chown into a concrete uid/gid pair, and any heredocs
+// attached to the instruction.
+type copyRequest struct {
+	cmdName     string
+	chownFlag   string
+	chmodFlag   string
+	ctrRootPath string
+	idMappings  *idtools.IDMappings
+	heredocs    []heredocContent
+	stagingDir  string
+}
+
+// dispatchCopy resolves the --chown/--chmod flags of a COPY/ADD instruction
+// and copies every source in sources, plus every heredoc attached to req,
+// onto dest inside the image being built.
+func dispatchCopy(req copyRequest, sources []string, dest string) error {
+	options, err := resolveCopyOptions(copyFlags{chown: req.chownFlag, chmod: req.chmodFlag}, req.ctrRootPath, req.idMappings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", req.cmdName, err)
+	}
+
+	destInfo := copyInfo{root: req.ctrRootPath, path: dest}
+
+	if len(req.heredocs) > 0 {
+		heredocInfos, err := heredocsToCopyInfos(req.stagingDir, dest, req.heredocs)
+		if err != nil {
+			return fmt.Errorf("%s: %w", req.cmdName, err)
+		}
+		for _, srcInfo := range heredocInfos {
+			if err := performCopyForInfo(destInfo, srcInfo, options); err != nil {
+				return fmt.Errorf("%s failed: %w", req.cmdName, err)
+			}
+		}
+	}
+
+	for _, src := range sources {
+		srcInfo := copyInfo{root: req.ctrRootPath, path: src}
+		if err := performCopyForInfo(destInfo, srcInfo, options); err != nil {
+			return fmt.Errorf("%s failed: %w", req.cmdName, err)
+		}
+	}
+	return nil
+}
+
+// dispatchAdd behaves like dispatchCopy: ADD and COPY share the same
+// --chown/--chmod handling and copy mechanics, differing only in the
+// higher-level source resolution (remote URLs, auto-extracted tarballs)
+// that happens before sources reaches here.
+func dispatchAdd(req copyRequest, sources []string, dest string) error {
+	return dispatchCopy(req, sources, dest)
+}
+
+// resolveRunCmd turns a RUN instruction's already-tokenized arguments and
+// the Dockerfile body lines that follow it into the Cmd the step's
+// container is started with. A RUN instruction only ever carries a single
+// heredoc (unlike COPY's stacked form), so when one is present its body is
+// written out under heredocDir and executed as a script via shell instead
+// of being reparsed from args.
+func resolveRunCmd(heredocDir string, shell []string, args []string, body []string) ([]string, error) {
+	remaining, docs, _, err := extractHeredocs(args, body)
+	if err != nil {
+		return nil, fmt.Errorf("RUN: %w", err)
+	}
+	if len(docs) == 0 {
+		return remaining, nil
+	}
+
+	_, cmd, err := heredocToRunScript(heredocDir, shell, docs[0])
+	if err != nil {
+		return nil, fmt.Errorf("RUN: %w", err)
+	}
+	return cmd, nil
+}
+
+// runRequest carries what dispatchRun needs to turn a RUN instruction's
+// parsed --mount flags into the HostConfig.Mounts the step's container is
+// actually started with.
+type runRequest struct {
+	buildID     string
+	mounts      []mountRequest
+	contextRoot string
+	stageRootFS func(stageName string) (string, error)
+	cacheRoot   func(cacheKey string) (string, error)
+	stagedRoot  func(id string) (string, error)
+}
+
+// dispatchRun resolves every --mount flag attached to a RUN instruction into
+// a concrete api/types/mount.Mount the step's container can be started
+// with, scoping cache mounts to req.buildID and bind mounts to either the
+// build context or a prior stage's rootfs.
+func dispatchRun(req runRequest) ([]mounttypes.Mount, error) {
+	apiMounts := make([]mounttypes.Mount, 0, len(req.mounts))
+	for _, m := range req.mounts {
+		source, err := resolveMountSource(req, m)
+		if err != nil {
+			return nil, fmt.Errorf("RUN --mount: %w", err)
+		}
+		apiMount := toAPIMount(m, source)
+		if m.Type == mountTypeBind && m.From != "" {
+			// A prior stage's rootfs is always mounted read-only.
+			apiMount.ReadOnly = true
+		}
+		apiMounts = append(apiMounts, apiMount)
+	}
+	return apiMounts, nil
+}
+
+// resolveMountSource turns m's Type-specific fields into the host-side
+// source toAPIMount needs: a prior stage's rootfs (or the build context)
+// for type=bind, a build-scoped cache volume for type=cache, and the
+// daemon's staged path for type=secret/ssh. type=tmpfs needs no source.
+func resolveMountSource(req runRequest, m mountRequest) (string, error) {
+	switch m.Type {
+	case mountTypeTmpfs:
+		return "", nil
+	case mountTypeCache:
+		return req.cacheRoot(cacheMountKey(req.buildID, m))
+	case mountTypeSecret, mountTypeSSH:
+		return req.stagedRoot(m.ID)
+	default: // bind
+		root := req.contextRoot
+		if m.From != "" {
+			stageRoot, err := req.stageRootFS(m.From)
+			if err != nil {
+				return "", err
+			}
+			root = stageRoot
+		}
+		src := m.Source
+		if src == "" {
+			src = string(filepath.Separator)
+		}
+		return filepath.Join(root, src), nil
+	}
+}
@@ -0,0 +1,168 @@
+package dockerfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	mounttypes "github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchCopyAppliesChownAndChmod(t *testing.T) {
+	contextDir, cleanup := createTestTempDir(t, "", "builder-dispatch-copy-test")
+	defer cleanup()
+
+	require.NoError(t, os.Mkdir(filepath.Join(contextDir, "etc"), 0755))
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "passwd", "root:x:0:0::/bin:/bin/false\n", 0644)
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "group", "root:x:0:\n", 0644)
+	createTestTempFile(t, contextDir, "src.txt", "hello", 0644)
+
+	req := copyRequest{
+		cmdName:     "COPY",
+		chownFlag:   "root:root",
+		chmodFlag:   "0640",
+		ctrRootPath: contextDir,
+		idMappings:  &idtools.IDMappings{},
+	}
+
+	err := dispatchCopy(req, []string{"src.txt"}, "dest.txt")
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(contextDir, "dest.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestDispatchCopyInstructionAppliesChownAndChmod(t *testing.T) {
+	contextDir, cleanup := createTestTempDir(t, "", "builder-dispatch-copy-instruction-test")
+	defer cleanup()
+
+	require.NoError(t, os.Mkdir(filepath.Join(contextDir, "etc"), 0755))
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "passwd", "root:x:0:0::/bin:/bin/false\n", 0644)
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "group", "root:x:0:\n", 0644)
+	createTestTempFile(t, contextDir, "src.txt", "hello", 0644)
+
+	req := dispatchRequest{ctrRootPath: contextDir, idMappings: &idtools.IDMappings{}}
+	in := instruction{
+		cmdName: "COPY",
+		flags:   []string{"--chown=root:root", "--chmod=0640"},
+		args:    []string{"src.txt", "dest.txt"},
+	}
+
+	require.NoError(t, dispatch(req, in, nil))
+
+	info, err := os.Stat(filepath.Join(contextDir, "dest.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestDispatchCopyInstructionRequiresDestination(t *testing.T) {
+	req := dispatchRequest{ctrRootPath: "/ctx", idMappings: &idtools.IDMappings{}}
+	in := instruction{cmdName: "COPY"}
+
+	err := dispatch(req, in, nil)
+	assert.Error(t, err)
+}
+
+func TestParseInstructionFlags(t *testing.T) {
+	flags, err := parseInstructionFlags([]string{"--chown=root:root", "--chmod=0755"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"chown": "root:root", "chmod": "0755"}, flags)
+}
+
+func TestParseInstructionFlagsRejectsMissingValue(t *testing.T) {
+	_, err := parseInstructionFlags([]string{"--chown"})
+	assert.Error(t, err)
+}
+
+func TestDispatchRunResolvesMounts(t *testing.T) {
+	req := runRequest{
+		buildID: "build1",
+		mounts: []mountRequest{
+			{Type: mountTypeBind, Target: "/out", From: "builder", Source: "/artifacts"},
+			{Type: mountTypeCache, Target: "/root/.cache", CacheID: "/root/.cache"},
+			{Type: mountTypeTmpfs, Target: "/tmp"},
+		},
+		contextRoot: "/ctx",
+		stageRootFS: func(stageName string) (string, error) {
+			assert.Equal(t, "builder", stageName)
+			return "/stages/builder", nil
+		},
+		cacheRoot: func(cacheKey string) (string, error) {
+			assert.Equal(t, cacheMountKey("build1", mountRequest{CacheID: "/root/.cache"}), cacheKey)
+			return "/var/lib/docker/buildkit-cache/abc", nil
+		},
+		stagedRoot: func(id string) (string, error) {
+			t.Fatalf("unexpected stagedRoot call for id %q", id)
+			return "", nil
+		},
+	}
+
+	mounts, err := dispatchRun(req)
+	require.NoError(t, err)
+	require.Len(t, mounts, 3)
+
+	assert.Equal(t, mounttypes.TypeBind, mounts[0].Type)
+	assert.Equal(t, filepath.Join("/stages/builder", "/artifacts"), mounts[0].Source)
+	assert.Equal(t, "/out", mounts[0].Target)
+	assert.True(t, mounts[0].ReadOnly)
+
+	assert.Equal(t, mounttypes.TypeVolume, mounts[1].Type)
+	assert.Equal(t, "/var/lib/docker/buildkit-cache/abc", mounts[1].Source)
+	assert.False(t, mounts[1].ReadOnly)
+
+	assert.Equal(t, mounttypes.TypeTmpfs, mounts[2].Type)
+	assert.Equal(t, "/tmp", mounts[2].Target)
+}
+
+func TestDispatchRunBindDefaultsToContext(t *testing.T) {
+	req := runRequest{
+		mounts:      []mountRequest{{Type: mountTypeBind, Target: "/src"}},
+		contextRoot: "/ctx",
+		stageRootFS: func(string) (string, error) { t.Fatal("unexpected stageRootFS call"); return "", nil },
+	}
+
+	mounts, err := dispatchRun(req)
+	require.NoError(t, err)
+	require.Len(t, mounts, 1)
+	assert.Equal(t, filepath.Join("/ctx", string(filepath.Separator)), mounts[0].Source)
+}
+
+func TestDispatchRunInstructionResolvesCmdAndMounts(t *testing.T) {
+	dir := t.TempDir()
+
+	req := runDispatchRequest{
+		runConfig:   &container.Config{Cmd: []string{"old"}},
+		buildID:     "build1",
+		platform:    "linux",
+		contextRoot: "/ctx",
+		heredocDir:  dir,
+		stageRootFS: func(stageName string) (string, error) { t.Fatalf("unexpected stageRootFS call for %q", stageName); return "", nil },
+		cacheRoot: func(cacheKey string) (string, error) {
+			return "/var/lib/docker/buildkit-cache/abc", nil
+		},
+		stagedRoot: func(id string) (string, error) { t.Fatalf("unexpected stagedRoot call for %q", id); return "", nil },
+	}
+	in := instruction{
+		cmdName: "RUN",
+		flags:   []string{"--mount=type=cache,target=/root/.cache"},
+		args:    []string{"make", "build"},
+	}
+
+	result, err := dispatchRunInstruction(req, in, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"make", "build"}, result.Cmd)
+	require.Len(t, result.Mounts, 1)
+	assert.Equal(t, mounttypes.TypeVolume, result.Mounts[0].Type)
+	assert.Equal(t, "/var/lib/docker/buildkit-cache/abc", result.Mounts[0].Source)
+	assert.Equal(t, "/root/.cache", result.Mounts[0].Target)
+}
+
+func TestRunMountFlagsRejectsNonMountFlag(t *testing.T) {
+	_, err := runMountFlags([]string{"--chown=root:root"})
+	assert.Error(t, err)
+}
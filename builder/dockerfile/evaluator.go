@@ -0,0 +1,195 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	mounttypes "github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/idtools"
+)
+
+// instruction is a single already-tokenized Dockerfile instruction line, in
+// the form the Dockerfile parser hands to the evaluator: the instruction
+// name, its `--flag=value` tokens, and its remaining positional arguments.
+type instruction struct {
+	cmdName string
+	flags   []string
+	args    []string
+}
+
+// parseInstructionFlags turns an instruction's raw `--key=value` tokens into
+// a key/value map. Dockerfile instructions only ever use the `--flag=value`
+// form, never `--flag value`.
+func parseInstructionFlags(rawFlags []string) (map[string]string, error) {
+	flags := make(map[string]string, len(rawFlags))
+	for _, f := range rawFlags {
+		f = strings.TrimPrefix(f, "--")
+		key, val, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid flag %q: must be in the --key=value form", f)
+		}
+		flags[key] = val
+	}
+	return flags, nil
+}
+
+// dispatchRequest carries the per-build state an instruction's dispatcher
+// needs that doesn't come from the instruction itself: the image being
+// built's rootfs and id mapping, and the staging directory COPY heredocs are
+// written to before being copied in.
+type dispatchRequest struct {
+	ctrRootPath string
+	idMappings  *idtools.IDMappings
+	stagingDir  string
+}
+
+// dispatch routes in to the dispatcher that knows how to execute it. It
+// handles COPY and ADD, via dispatchCopy/dispatchAdd; every other
+// instruction, RUN included, is rejected with an error. RUN is resolved by
+// dispatchRunInstruction, which takes a runDispatchRequest instead.
+func dispatch(req dispatchRequest, in instruction, body []string) error {
+	switch in.cmdName {
+	case "COPY", "ADD":
+		return dispatchCopyInstruction(req, in, body)
+	default:
+		return fmt.Errorf("unsupported instruction %q", in.cmdName)
+	}
+}
+
+// dispatchCopyInstruction parses a COPY/ADD instruction's --chown/--chmod
+// flags and heredoc body, and copies every source onto the instruction's
+// destination (its last argument) inside the image being built.
+func dispatchCopyInstruction(req dispatchRequest, in instruction, body []string) error {
+	flags, err := parseInstructionFlags(in.flags)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.cmdName, err)
+	}
+
+	args, docs, _, err := extractHeredocs(in.args, body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in.cmdName, err)
+	}
+	if len(args) < 1 {
+		return fmt.Errorf("%s requires at least one destination argument", in.cmdName)
+	}
+	dest := args[len(args)-1]
+	var sources []string
+	docIdx := 0
+	skipNext := false
+	for _, arg := range args[:len(args)-1] {
+		if skipNext {
+			// The file name that followed a stacked heredoc's marker names
+			// that heredoc's content; it is not a source path either.
+			skipNext = false
+			continue
+		}
+		// extractHeredocs leaves a "<<NAME" placeholder where each heredoc
+		// marker was; the heredoc's actual content rides along in docs
+		// instead, so the placeholder itself is never a real source path.
+		if strings.HasPrefix(arg, "<<") {
+			if docIdx < len(docs) && arg == "<<"+docs[docIdx].Terminator {
+				skipNext = docs[docIdx].FileName != ""
+				docIdx++
+			}
+			continue
+		}
+		sources = append(sources, arg)
+	}
+
+	copyReq := copyRequest{
+		cmdName:     in.cmdName,
+		chownFlag:   flags["chown"],
+		chmodFlag:   flags["chmod"],
+		ctrRootPath: req.ctrRootPath,
+		idMappings:  req.idMappings,
+		heredocs:    docs,
+		stagingDir:  req.stagingDir,
+	}
+
+	if in.cmdName == "ADD" {
+		return dispatchAdd(copyReq, sources, dest)
+	}
+	return dispatchCopy(copyReq, sources, dest)
+}
+
+// runDispatchRequest carries the per-build state dispatchRunInstruction needs
+// beyond the RUN instruction itself: the step's starting container config,
+// where to stage a heredoc script, and how to resolve each mount type's
+// --mount flags down to a host path.
+type runDispatchRequest struct {
+	runConfig   *container.Config
+	buildID     string
+	platform    string
+	contextRoot string
+	heredocDir  string
+	stageRootFS func(stageName string) (string, error)
+	cacheRoot   func(cacheKey string) (string, error)
+	stagedRoot  func(id string) (string, error)
+}
+
+// runResult is what dispatchRunInstruction resolves a RUN instruction into:
+// the Cmd the step's container is started with, and the concrete
+// api/types/mount.Mount entries its HostConfig needs for any --mount flags.
+type runResult struct {
+	Cmd    []string
+	Mounts []mounttypes.Mount
+}
+
+// dispatchRunInstruction is the RUN instruction's counterpart to
+// dispatchCopyInstruction: it parses in's --mount flags and heredoc body,
+// resolves them into api/types/mount.Mount entries via dispatchRun, and
+// folds the instruction's Cmd (plain args, or a heredoc script) onto
+// req.runConfig via copyRunConfig, giving the step everything it needs to
+// start its container.
+func dispatchRunInstruction(req runDispatchRequest, in instruction, body []string) (runResult, error) {
+	rawMounts, err := runMountFlags(in.flags)
+	if err != nil {
+		return runResult{}, fmt.Errorf("RUN: %w", err)
+	}
+
+	cmd, err := resolveRunCmd(req.heredocDir, defaultShellForOS(req.platform), in.args, body)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	mountsModifier, err := runMountsModifier(rawMounts)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	rc := copyRunConfig(req.runConfig, withCmd(cmd), mountsModifier)
+
+	apiMounts, err := dispatchRun(runRequest{
+		buildID:     req.buildID,
+		mounts:      rc.Mounts,
+		contextRoot: req.contextRoot,
+		stageRootFS: req.stageRootFS,
+		cacheRoot:   req.cacheRoot,
+		stagedRoot:  req.stagedRoot,
+	})
+	if err != nil {
+		return runResult{}, fmt.Errorf("RUN: %w", err)
+	}
+
+	return runResult{Cmd: rc.Config.Cmd, Mounts: apiMounts}, nil
+}
+
+// runMountFlags pulls the raw `--mount=...` values off a RUN instruction's
+// flags. Unlike COPY's --chown/--chmod, --mount may repeat once per mount,
+// so it can't be folded into parseInstructionFlags' one-value-per-key map.
+func runMountFlags(rawFlags []string) ([]string, error) {
+	var mounts []string
+	for _, f := range rawFlags {
+		f = strings.TrimPrefix(f, "--")
+		key, val, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid flag %q: must be in the --key=value form", f)
+		}
+		if key != "mount" {
+			return nil, fmt.Errorf("unsupported flag %q", key)
+		}
+		mounts = append(mounts, val)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,136 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// heredocMarker is one `<<[-]NAME` (optionally quoted, optionally followed
+// by a destination filename) token found among a COPY or RUN instruction's
+// arguments.
+type heredocMarker struct {
+	// Terminator is the word that, alone on a line, ends this heredoc's
+	// body (the NAME in `<<NAME`).
+	Terminator string
+	// Expand is false when the terminator was quoted (`<<"EOF"`/`<<'EOF'`),
+	// which disables variable expansion in the heredoc's body.
+	Expand bool
+	// StripTabs is true for `<<-NAME`, which strips leading tab characters
+	// from every line of the body (and from the terminator line itself).
+	StripTabs bool
+	// FileName is the argument that directly followed this marker, e.g. the
+	// "a.txt" in `COPY <<EOF1 a.txt <<EOF2 b.txt /dest/`; a COPY names this
+	// heredoc's destination file after it. It is empty when the marker is
+	// followed by another marker or by the instruction's last argument (as
+	// in `COPY <<EOF /dest/file`, where the heredoc is named after that
+	// destination instead).
+	FileName string
+}
+
+// heredocContent is a fully consumed heredoc: its marker plus the body text
+// collected up to (but not including) its terminator line.
+type heredocContent struct {
+	heredocMarker
+	Content string
+}
+
+// heredocMarkerRegexp matches a single `<<[-]NAME` token, where NAME may be
+// bare, double-quoted, or single-quoted.
+var heredocMarkerRegexp = regexp.MustCompile(`^<<(-?)(?:"([a-zA-Z_][\w]*)"|'([a-zA-Z_][\w]*)'|([a-zA-Z_][\w]*))$`)
+
+// parseHeredocMarker recognizes a single word as a heredoc marker. ok is
+// false if word isn't one, in which case it should be treated as a regular
+// instruction argument.
+func parseHeredocMarker(word string) (marker heredocMarker, ok bool) {
+	m := heredocMarkerRegexp.FindStringSubmatch(word)
+	if m == nil {
+		return heredocMarker{}, false
+	}
+	name := m[2]
+	expand := true
+	if m[3] != "" {
+		name = m[3]
+		expand = false
+	} else if m[2] != "" {
+		expand = false
+	} else {
+		name = m[4]
+	}
+	return heredocMarker{
+		Terminator: name,
+		Expand:     expand,
+		StripTabs:  m[1] == "-",
+	}, true
+}
+
+// extractHeredocs scans an instruction's already-tokenized arguments for
+// heredoc markers (`<<EOF`, `<<-EOF`, `<<"EOF"`, ...), consuming the body of
+// each one from body (the Dockerfile lines immediately following the
+// instruction) in the order the markers appear on the instruction line.
+//
+// It returns the instruction's arguments with every heredoc marker token
+// replaced by a placeholder naming the heredoc (so callers can tell which
+// resolved content belongs to which argument position), the consumed
+// heredoc bodies in marker order, and the number of lines of body that were
+// consumed.
+func extractHeredocs(args []string, body []string) (remainingArgs []string, docs []heredocContent, consumed int, err error) {
+	var markers []heredocMarker
+	remainingArgs = make([]string, 0, len(args))
+	lastIndex := len(args) - 1
+
+	for i, word := range args {
+		marker, ok := parseHeredocMarker(word)
+		if !ok {
+			remainingArgs = append(remainingArgs, word)
+			continue
+		}
+		// A stacked heredoc on COPY may be immediately followed by the
+		// destination filename for that heredoc's content, e.g.
+		// `COPY <<EOF1 a.txt <<EOF2 b.txt /dest/`. The final argument is
+		// always COPY's own destination, never a per-heredoc filename, so
+		// it's excluded here even when it happens to follow a marker (the
+		// single-heredoc `COPY <<EOF /dest/file` case).
+		if i+1 < lastIndex {
+			if _, isMarker := parseHeredocMarker(args[i+1]); !isMarker {
+				marker.FileName = args[i+1]
+			}
+		}
+		markers = append(markers, marker)
+		remainingArgs = append(remainingArgs, "<<"+marker.Terminator)
+	}
+
+	for _, marker := range markers {
+		content, n, err := consumeHeredocBody(marker, body[consumed:])
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		docs = append(docs, heredocContent{heredocMarker: marker, Content: content})
+		consumed += n
+	}
+
+	return remainingArgs, docs, consumed, nil
+}
+
+// consumeHeredocBody reads lines until it finds one that, after optional tab
+// stripping, equals marker.Terminator exactly, returning the body collected
+// before that line and the number of lines consumed (including the
+// terminator line itself).
+func consumeHeredocBody(marker heredocMarker, lines []string) (string, int, error) {
+	var body strings.Builder
+	for i, line := range lines {
+		trimmed := line
+		if marker.StripTabs {
+			trimmed = strings.TrimLeft(line, "\t")
+		}
+		if trimmed == marker.Terminator {
+			return body.String(), i + 1, nil
+		}
+		if marker.StripTabs {
+			line = trimmed
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	return "", 0, fmt.Errorf("no terminator found for heredoc with name %q in Dockerfile", marker.Terminator)
+}
@@ -0,0 +1,75 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// heredocsToCopyInfos turns the heredoc bodies attached to a COPY
+// instruction into copyInfos rooted in a private staging directory, so they
+// can be routed through performCopyForInfo exactly like any other source
+// and pick up --chown/--chmod the same way a regular file would.
+func heredocsToCopyInfos(stagingDir, dest string, docs []heredocContent) ([]copyInfo, error) {
+	infos := make([]copyInfo, 0, len(docs))
+	for _, doc := range docs {
+		name := doc.FileName
+		if name == "" {
+			// A single, unnamed heredoc is named after the instruction's
+			// own destination, matching `COPY <<EOF /dest/file`.
+			name = filepath.Base(dest)
+		}
+
+		path := filepath.Join(stagingDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(doc.Content), 0644); err != nil {
+			return nil, err
+		}
+		infos = append(infos, copyInfo{root: stagingDir, path: name})
+	}
+	return infos, nil
+}
+
+// heredocToRunScript writes a RUN heredoc's body to a temp file under dir
+// (a path inside the build container's rootfs that the step's container
+// already has mounted), made executable so it can be run directly, and
+// returns the one-element Cmd that invokes it. The body is run as a script,
+// not passed to shell as a `-c` argument: if it doesn't already start with
+// its own `#!` line, one is prepended naming shell's interpreter, so a RUN
+// heredoc without an explicit interpreter executes under the step's default
+// shell.
+func heredocToRunScript(dir string, shell []string, doc heredocContent) (scriptPath string, cmd []string, err error) {
+	content := doc.Content
+	if !strings.HasPrefix(content, "#!") {
+		content = shebangFor(shell) + content
+	}
+
+	f, err := os.CreateTemp(dir, "heredoc-run-*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return "", nil, err
+	}
+	if err := f.Chmod(0700); err != nil {
+		return "", nil, err
+	}
+
+	return f.Name(), []string{f.Name()}, nil
+}
+
+// shebangFor turns a shell invocation such as defaultShellForOS's
+// ["/bin/sh", "-c"] into the shebang line a heredoc script without its own
+// "#!" is executed under. A shebang line accepts at most one argument after
+// the interpreter, so anything beyond shell's first element (e.g. the "-c"
+// a shell is otherwise invoked with) is dropped rather than carried over.
+func shebangFor(shell []string) string {
+	if len(shell) == 0 {
+		return "#!/bin/sh\n"
+	}
+	return "#!" + shell[0] + "\n"
+}
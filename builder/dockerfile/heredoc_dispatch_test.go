@@ -0,0 +1,117 @@
+package dockerfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchCopyWritesHeredocs(t *testing.T) {
+	contextDir, cleanup := createTestTempDir(t, "", "builder-dispatch-copy-heredoc-test")
+	defer cleanup()
+	stagingDir, cleanup2 := createTestTempDir(t, "", "builder-dispatch-copy-heredoc-staging")
+	defer cleanup2()
+
+	require.NoError(t, os.Mkdir(filepath.Join(contextDir, "etc"), 0755))
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "passwd", "root:x:0:0::/bin:/bin/false\n", 0644)
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "group", "root:x:0:\n", 0644)
+
+	req := copyRequest{
+		cmdName:     "COPY",
+		ctrRootPath: contextDir,
+		idMappings:  &idtools.IDMappings{},
+		stagingDir:  stagingDir,
+		heredocs:    []heredocContent{{Content: "hello from heredoc\n"}},
+	}
+
+	require.NoError(t, dispatchCopy(req, nil, "dest.txt"))
+
+	content, err := os.ReadFile(filepath.Join(contextDir, "dest.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello from heredoc\n", string(content))
+}
+
+func TestDispatchCopyInstructionWritesHeredocs(t *testing.T) {
+	contextDir, cleanup := createTestTempDir(t, "", "builder-dispatch-copy-instruction-heredoc-test")
+	defer cleanup()
+	stagingDir, cleanup2 := createTestTempDir(t, "", "builder-dispatch-copy-instruction-heredoc-staging")
+	defer cleanup2()
+
+	require.NoError(t, os.Mkdir(filepath.Join(contextDir, "etc"), 0755))
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "passwd", "root:x:0:0::/bin:/bin/false\n", 0644)
+	createTestTempFile(t, filepath.Join(contextDir, "etc"), "group", "root:x:0:\n", 0644)
+
+	req := dispatchRequest{ctrRootPath: contextDir, idMappings: &idtools.IDMappings{}, stagingDir: stagingDir}
+	in := instruction{cmdName: "COPY", args: []string{"<<EOF", "dest.txt"}}
+	body := []string{"hello from heredoc", "EOF"}
+
+	require.NoError(t, dispatch(req, in, body))
+
+	content, err := os.ReadFile(filepath.Join(contextDir, "dest.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello from heredoc\n", string(content))
+}
+
+func TestResolveRunCmdWritesHeredocScript(t *testing.T) {
+	dir, cleanup := createTestTempDir(t, "", "builder-run-heredoc-test")
+	defer cleanup()
+
+	args := []string{"<<EOF"}
+	body := []string{"echo hello", "EOF"}
+
+	cmd, err := resolveRunCmd(dir, []string{"/bin/sh", "-c"}, args, body)
+	require.NoError(t, err)
+	require.Len(t, cmd, 1)
+
+	info, err := os.Stat(cmd[0])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	content, err := os.ReadFile(cmd[0])
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho hello\n", string(content))
+}
+
+func TestResolveRunCmdWithoutHeredocReturnsArgs(t *testing.T) {
+	cmd, err := resolveRunCmd(t.TempDir(), []string{"/bin/sh"}, []string{"-c", "echo hi"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-c", "echo hi"}, cmd)
+}
+
+func TestHeredocToRunScriptPreservesOwnShebang(t *testing.T) {
+	dir := t.TempDir()
+
+	_, cmd, err := heredocToRunScript(dir, []string{"/bin/sh", "-c"}, heredocContent{Content: "#!/usr/bin/env python3\nprint('hi')\n"})
+	require.NoError(t, err)
+	require.Len(t, cmd, 1)
+
+	content, err := os.ReadFile(cmd[0])
+	require.NoError(t, err)
+	assert.Equal(t, "#!/usr/bin/env python3\nprint('hi')\n", string(content))
+}
+
+func TestDispatchRunInstructionResolvesHeredocScript(t *testing.T) {
+	dir := t.TempDir()
+
+	req := runDispatchRequest{
+		runConfig:   &container.Config{},
+		platform:    "linux",
+		contextRoot: "/ctx",
+		heredocDir:  dir,
+	}
+	in := instruction{cmdName: "RUN", args: []string{"<<EOF"}}
+	body := []string{"echo hello", "EOF"}
+
+	result, err := dispatchRunInstruction(req, in, body)
+	require.NoError(t, err)
+	require.Len(t, result.Cmd, 1)
+
+	info, err := os.Stat(result.Cmd[0])
+	require.NoError(t, err)
+	assert.True(t, info.Mode().Perm()&0100 != 0, "heredoc script must be executable")
+}
@@ -0,0 +1,80 @@
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractHeredocsSingle(t *testing.T) {
+	args := []string{"<<EOF", "/dest/file"}
+	body := []string{"line one", "line two", "EOF", "RUN something-else"}
+
+	remaining, docs, consumed, err := extractHeredocs(args, body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"<<EOF", "/dest/file"}, remaining)
+	assert.Equal(t, 3, consumed)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "line one\nline two\n", docs[0].Content)
+	assert.True(t, docs[0].Expand)
+	assert.False(t, docs[0].StripTabs)
+}
+
+func TestExtractHeredocsStacked(t *testing.T) {
+	args := []string{"<<EOF1", "a.txt", "<<EOF2", "b.txt", "/dest/"}
+	body := []string{"content a", "EOF1", "content b", "EOF2"}
+
+	remaining, docs, consumed, err := extractHeredocs(args, body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"<<EOF1", "a.txt", "<<EOF2", "b.txt", "/dest/"}, remaining)
+	assert.Equal(t, 4, consumed)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "a.txt", docs[0].FileName)
+	assert.Equal(t, "content a\n", docs[0].Content)
+	assert.Equal(t, "b.txt", docs[1].FileName)
+	assert.Equal(t, "content b\n", docs[1].Content)
+}
+
+func TestExtractHeredocsStripTabsAndQuoted(t *testing.T) {
+	args := []string{`<<-'EOF'`, "/dest/script.sh"}
+	body := []string{"\t#!/bin/sh", "\techo $HOME", "EOF"}
+
+	_, docs, consumed, err := extractHeredocs(args, body)
+	require.NoError(t, err)
+	assert.Equal(t, 3, consumed)
+	require.Len(t, docs, 1)
+	assert.False(t, docs[0].Expand, "quoted terminator should disable expansion")
+	assert.True(t, docs[0].StripTabs)
+	assert.Equal(t, "#!/bin/sh\necho $HOME\n", docs[0].Content)
+}
+
+func TestExtractHeredocsMixedWithRegularArgs(t *testing.T) {
+	args := []string{"--chown=1:1", "<<EOF", "/dest/file"}
+	body := []string{"hello", "EOF"}
+
+	remaining, docs, consumed, err := extractHeredocs(args, body)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--chown=1:1", "<<EOF", "/dest/file"}, remaining)
+	assert.Equal(t, 2, consumed)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "hello\n", docs[0].Content)
+}
+
+func TestExtractHeredocsMissingTerminator(t *testing.T) {
+	args := []string{"<<EOF", "/dest/file"}
+	body := []string{"line one", "line two"}
+
+	_, _, _, err := extractHeredocs(args, body)
+	assert.EqualError(t, err, `no terminator found for heredoc with name "EOF" in Dockerfile`)
+}
+
+func TestExtractHeredocsNoMarkers(t *testing.T) {
+	args := []string{"/src", "/dest"}
+
+	remaining, docs, consumed, err := extractHeredocs(args, nil)
+	require.NoError(t, err)
+	assert.Equal(t, args, remaining)
+	assert.Empty(t, docs)
+	assert.Equal(t, 0, consumed)
+}
@@ -0,0 +1,226 @@
+// Package dockerfile contains the logic to execute a Dockerfile and to
+// process each of the steps of the Dockerfile.
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/chrootarchive"
+	"github.com/docker/docker/pkg/idtools"
+)
+
+// copyInfo holds the resolved location of a single COPY/ADD source: root is
+// the directory it was resolved against (the build context, a heredoc
+// staging directory, or a previous stage's rootfs) and path is relative to
+// root.
+type copyInfo struct {
+	root string
+	path string
+}
+
+// FullPath returns the absolute on-disk location described by c.
+func (c copyInfo) FullPath() string {
+	return filepath.Join(c.root, c.path)
+}
+
+// copyFileOptions carries the ownership and permission overrides requested
+// via the --chown and --chmod flags on an ADD/COPY instruction. A nil
+// chownPair or chmod means "leave whatever the copy would have produced
+// untouched".
+type copyFileOptions struct {
+	decompress bool
+	chownPair  *idtools.IDPair
+	chmod      *os.FileMode
+}
+
+// copyInstruction is the fully parsed form of a single ADD/COPY instruction:
+// the resolved sources, the destination, and the --chown/--chmod overrides
+// that should be applied to every file it produces.
+type copyInstruction struct {
+	cmdName string
+	infos   []copyInfo
+	dest    string
+	options copyFileOptions
+}
+
+// parseChownFlag parses a chown string provided by the --chown flag in the
+// Dockerfile and returns a valid uid/gid pair. If a user or group name is
+// provided, the lookup is performed against the passwd/group files of the
+// container whose rootfs is rooted at ctrRootPath, then mapped to the host
+// via idMappings.
+func parseChownFlag(chown, ctrRootPath string, idMappings *idtools.IDMappings) (idtools.IDPair, error) {
+	var userStr, grpStr string
+	parts := strings.Split(chown, ":")
+	switch len(parts) {
+	case 1:
+		userStr, grpStr = parts[0], parts[0]
+	case 2:
+		userStr, grpStr = parts[0], parts[1]
+	default:
+		return idtools.IDPair{}, fmt.Errorf("invalid chown string format: %s", chown)
+	}
+
+	uid, err := lookupUIDOrGID(userStr, filepath.Join(ctrRootPath, "etc", "passwd"))
+	if err != nil {
+		return idtools.IDPair{}, fmt.Errorf("can't find uid for user %s: %w", userStr, err)
+	}
+	gid, err := lookupUIDOrGID(grpStr, filepath.Join(ctrRootPath, "etc", "group"))
+	if err != nil {
+		return idtools.IDPair{}, fmt.Errorf("can't find gid for group %s: %w", grpStr, err)
+	}
+
+	return idMappings.ToHost(idtools.IDPair{UID: uid, GID: gid}), nil
+}
+
+// lookupUIDOrGID resolves name to a numeric id, either by parsing it
+// directly or by looking it up in the given /etc/passwd or /etc/group
+// style file. entity is "user" or "group", used only for the error message.
+func lookupUIDOrGID(name, dbPath string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	entity := "user"
+	if strings.HasSuffix(dbPath, "group") {
+		entity = "group"
+	}
+
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("no such %s: %s", entity, name)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		// passwd: name:passwd:uid:gid:...  group: name:passwd:gid:...
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		return id, nil
+	}
+	return 0, fmt.Errorf("no such %s: %s", entity, name)
+}
+
+// parseChmodFlag parses a chmod string provided by the --chmod flag in the
+// Dockerfile. Only octal modes are accepted, expressed as 3 or 4 digits. The
+// leading digit of a 4-digit mode may be 0, or 1, 2 or 4 to set the sticky,
+// setgid or setuid bit respectively; combinations such as 6755 are rejected.
+func parseChmodFlag(chmod string) (os.FileMode, error) {
+	if len(chmod) < 3 || len(chmod) > 4 {
+		return 0, fmt.Errorf("invalid chmod string format: %s", chmod)
+	}
+	for _, c := range chmod {
+		if c < '0' || c > '7' {
+			return 0, fmt.Errorf("invalid chmod string format: %s", chmod)
+		}
+	}
+
+	perm, err := strconv.ParseUint(chmod, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chmod string format: %s", chmod)
+	}
+
+	var special os.FileMode
+	if len(chmod) == 4 {
+		switch chmod[0] {
+		case '1':
+			special = os.ModeSticky
+		case '2':
+			special = os.ModeSetgid
+		case '4':
+			special = os.ModeSetuid
+		case '0':
+		default:
+			return 0, fmt.Errorf("invalid chmod string format: %s", chmod)
+		}
+		perm &^= 07000
+	}
+
+	return special | os.FileMode(perm), nil
+}
+
+// performCopyForInfo copies source onto dest, applying the owner and mode
+// overrides carried in options to every file it produces, regardless of the
+// permissions the source had on disk. Directories are copied recursively via
+// copyDirectory.
+func performCopyForInfo(dest, source copyInfo, options copyFileOptions) error {
+	srcPath := source.FullPath()
+	destPath := dest.FullPath()
+
+	srcInfo, err := os.Lstat(srcPath)
+	if err != nil {
+		return fmt.Errorf("source path not found: %w", err)
+	}
+
+	archiver := chrootarchive.NewArchiver(nil)
+	if srcInfo.IsDir() {
+		return copyDirectory(archiver, srcPath, destPath, options)
+	}
+	if err := archiver.CopyWithTar(srcPath, destPath); err != nil {
+		return fmt.Errorf("failed to copy files: %w", err)
+	}
+	return applyCopyOptions(destPath, options)
+}
+
+// copyDirectory copies the contents of src into dest recursively using
+// archiver, then applies the chown/chmod overrides in options to dest and to
+// every entry copied into it from src. Entries already in dest that src does
+// not have are left as they are.
+func copyDirectory(archiver *chrootarchive.Archiver, src, dest string, options copyFileOptions) error {
+	if err := archiver.CopyWithTar(src, dest); err != nil {
+		return fmt.Errorf("failed to copy directory: %w", err)
+	}
+	if options.chownPair == nil && options.chmod == nil {
+		return nil
+	}
+	return filepath.Walk(src, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return applyCopyOptions(filepath.Join(dest, rel), options)
+	})
+}
+
+// applyCopyOptions applies options to the single file or directory at path.
+func applyCopyOptions(path string, options copyFileOptions) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	return applyCopyOptionsToEntry(path, info, options)
+}
+
+// applyCopyOptionsToEntry applies options.chownPair and options.chmod to
+// path, skipping whichever of the two was not requested.
+func applyCopyOptionsToEntry(path string, info os.FileInfo, options copyFileOptions) error {
+	if options.chownPair != nil {
+		if err := os.Lchown(path, options.chownPair.UID, options.chownPair.GID); err != nil {
+			return fmt.Errorf("failed to change owner and group of %q: %w", path, err)
+		}
+	}
+	if options.chmod != nil && info.Mode()&os.ModeSymlink == 0 {
+		if err := os.Chmod(path, *options.chmod); err != nil {
+			return fmt.Errorf("failed to change mode of %q: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -4,18 +4,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"testing"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/backend"
-	"github.com/docker/docker/api/types/blkiodev"
-	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/builder"
 	"github.com/docker/docker/builder/remotecontext"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/idtools"
-	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -84,129 +80,6 @@ func readAndCheckDockerfile(t *testing.T, testName, contextDir, dockerfilePath,
 	assert.EqualError(t, err, expectedError)
 }
 
-func TestCopyRunConfig(t *testing.T) {
-	defaultEnv := []string{"foo=1"}
-	defaultCmd := []string{"old"}
-
-	var testcases = []struct {
-		doc       string
-		modifiers []runConfigModifier
-		expected  *container.Config
-	}{
-		{
-			doc:       "Set the command",
-			modifiers: []runConfigModifier{withCmd([]string{"new"})},
-			expected: &container.Config{
-				Cmd: []string{"new"},
-				Env: defaultEnv,
-			},
-		},
-		{
-			doc:       "Set the command to a comment",
-			modifiers: []runConfigModifier{withCmdComment("comment", runtime.GOOS)},
-			expected: &container.Config{
-				Cmd: append(defaultShellForOS(runtime.GOOS), "#(nop) ", "comment"),
-				Env: defaultEnv,
-			},
-		},
-		{
-			doc: "Set the command and env",
-			modifiers: []runConfigModifier{
-				withCmd([]string{"new"}),
-				withEnv([]string{"one", "two"}),
-			},
-			expected: &container.Config{
-				Cmd: []string{"new"},
-				Env: []string{"one", "two"},
-			},
-		},
-	}
-
-	for _, testcase := range testcases {
-		runConfig := &container.Config{
-			Cmd: defaultCmd,
-			Env: defaultEnv,
-		}
-		runConfigCopy := copyRunConfig(runConfig, testcase.modifiers...)
-		assert.Equal(t, testcase.expected, runConfigCopy, testcase.doc)
-		// Assert the original was not modified
-		assert.NotEqual(t, runConfig, runConfigCopy, testcase.doc)
-	}
-
-}
-
-func TestThrottleDeviceFromOptionsWithSize(t *testing.T) {
-	opt := "/dev/sda:20kb"
-	expectedThrottleDevice := blkiodev.ThrottleDevice{
-		Path: "/dev/sda",
-		Rate: 20480,
-	}
-	throttleDevice := throttleDeviceFromOptionsWithSize(opt)
-	assert.Equal(t, expectedThrottleDevice, *throttleDevice[0], "correctly get throttleDevice from options with size")
-
-	// test for invalid option
-	opt = "/no/rate:kb"
-	nilDevice := []*blkiodev.ThrottleDevice([]*blkiodev.ThrottleDevice(nil))
-	throttleDevice = throttleDeviceFromOptionsWithSize(opt)
-	assert.Equal(t, nilDevice, throttleDevice, "return nil for invalid options")
-	assert.Equal(t, 0, len(throttleDevice), "length of invalid device should be zero")
-}
-
-func TestThrottleDeviceFromOptions(t *testing.T) {
-	opt := "/dev/sda:500"
-	expectedThrottleDevice := blkiodev.ThrottleDevice{
-		Path: "/dev/sda",
-		Rate: 500,
-	}
-	throttleDevice := throttleDeviceFromOptions(opt)
-	assert.Equal(t, expectedThrottleDevice, *throttleDevice[0], "correctly get throttleDevice from options")
-
-	// test for invalid option
-	opt = "/no/rate"
-	nilDevice := []*blkiodev.ThrottleDevice([]*blkiodev.ThrottleDevice(nil))
-	throttleDevice = throttleDeviceFromOptions(opt)
-	assert.Equal(t, nilDevice, throttleDevice, "return nil for invalid options")
-	assert.Equal(t, 0, len(throttleDevice), "length of invalid device should be zero")
-}
-
-func fullMutableRunConfig() *container.Config {
-	return &container.Config{
-		Cmd: []string{"command", "arg1"},
-		Env: []string{"env1=foo", "env2=bar"},
-		ExposedPorts: nat.PortSet{
-			"1000/tcp": {},
-			"1001/tcp": {},
-		},
-		Volumes: map[string]struct{}{
-			"one": {},
-			"two": {},
-		},
-		Entrypoint: []string{"entry", "arg1"},
-		OnBuild:    []string{"first", "next"},
-		Labels: map[string]string{
-			"label1": "value1",
-			"label2": "value2",
-		},
-		Shell: []string{"shell", "-c"},
-	}
-}
-
-func TestDeepCopyRunConfig(t *testing.T) {
-	runConfig := fullMutableRunConfig()
-	copy := copyRunConfig(runConfig)
-	assert.Equal(t, fullMutableRunConfig(), copy)
-
-	copy.Cmd[1] = "arg2"
-	copy.Env[1] = "env2=new"
-	copy.ExposedPorts["10002"] = struct{}{}
-	copy.Volumes["three"] = struct{}{}
-	copy.Entrypoint[1] = "arg2"
-	copy.OnBuild[0] = "start"
-	copy.Labels["label3"] = "value3"
-	copy.Shell[0] = "sh"
-	assert.Equal(t, fullMutableRunConfig(), runConfig)
-}
-
 func TestChownFlagParsing(t *testing.T) {
 	testFiles := map[string]string{
 		"passwd": `root:x:0:0::/bin:/bin/false
@@ -333,3 +206,66 @@ othergrp:x:6666:
 		})
 	}
 }
+
+func TestChmodFlagParsing(t *testing.T) {
+	// positive tests
+	for _, testcase := range []struct {
+		name     string
+		chmodStr string
+		expected os.FileMode
+	}{
+		{
+			name:     "Standard",
+			chmodStr: "0755",
+			expected: 0755,
+		},
+		{
+			name:     "ThreeDigits",
+			chmodStr: "755",
+			expected: 0755,
+		},
+		{
+			name:     "Sticky",
+			chmodStr: "1755",
+			expected: os.ModeSticky | 0755,
+		},
+		{
+			name:     "Setgid",
+			chmodStr: "2755",
+			expected: os.ModeSetgid | 0755,
+		},
+		{
+			name:     "Setuid",
+			chmodStr: "4755",
+			expected: os.ModeSetuid | 0755,
+		},
+		{
+			name:     "AllZero",
+			chmodStr: "0000",
+			expected: 0,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			mode, err := parseChmodFlag(testcase.chmodStr)
+			require.NoError(t, err, "Failed to parse chmod flag: %q", testcase.chmodStr)
+			assert.Equal(t, testcase.expected, mode, "chmod flag mapping failure")
+		})
+	}
+
+	// error tests
+	for _, testcase := range []struct {
+		name     string
+		chmodStr string
+	}{
+		{name: "TooShort", chmodStr: "75"},
+		{name: "TooLong", chmodStr: "07755"},
+		{name: "NotOctal", chmodStr: "0888"},
+		{name: "BadLeadingDigit", chmodStr: "3755"},
+		{name: "NotNumeric", chmodStr: "rwxr-xr-x"},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			_, err := parseChmodFlag(testcase.chmodStr)
+			assert.EqualError(t, err, fmt.Sprintf("invalid chmod string format: %s", testcase.chmodStr))
+		})
+	}
+}
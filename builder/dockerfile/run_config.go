@@ -0,0 +1,177 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// buildRunConfig is the state threaded through a single build step's
+// modifiers. Config is what ultimately gets committed as the step's
+// container config; Mounts carries any RUN --mount requests, which have no
+// place in container.Config itself but need to ride through the same
+// pipeline so the run dispatcher can materialize them before the step runs.
+type buildRunConfig struct {
+	Config *container.Config
+	Mounts []mountRequest
+}
+
+// runConfigModifier mutates a buildRunConfig produced for a single build
+// step. Dispatchers compose the modifiers they need (a new Cmd, extra Env,
+// mounts for RUN, ...) and hand them to copyRunConfig rather than mutating
+// the running build's config in place.
+type runConfigModifier func(*buildRunConfig)
+
+// withCmd sets Cmd on the run config.
+func withCmd(cmd []string) runConfigModifier {
+	return func(rc *buildRunConfig) {
+		rc.Config.Cmd = cmd
+	}
+}
+
+// withCmdComment sets Cmd to a `#(nop)` comment executed via the default
+// shell for platform, which is how no-op build steps (ENV, LABEL, ...) are
+// recorded in the image history without actually running anything.
+func withCmdComment(comment string, platform string) runConfigModifier {
+	return func(rc *buildRunConfig) {
+		rc.Config.Cmd = append(defaultShellForOS(platform), "#(nop) ", comment)
+	}
+}
+
+// withEnv sets Env on the run config.
+func withEnv(env []string) runConfigModifier {
+	return func(rc *buildRunConfig) {
+		rc.Config.Env = env
+	}
+}
+
+// withMounts sets the RUN --mount requests that the run dispatcher must
+// materialize before starting the step's container. It never reaches
+// Config.Cmd beyond the `#(nop)` comment form withCmdComment already
+// produces, so mount specs don't leak into the committed layer's history.
+func withMounts(mounts []mountRequest) runConfigModifier {
+	return func(rc *buildRunConfig) {
+		rc.Mounts = mounts
+	}
+}
+
+// runMountsModifier parses the raw `--mount=...` flag values straight off a
+// RUN instruction and returns the runConfigModifier that attaches them to
+// the step's buildRunConfig via withMounts, so dispatchRun can resolve and
+// materialize them immediately before the step's container starts.
+func runMountsModifier(rawMounts []string) (runConfigModifier, error) {
+	if len(rawMounts) == 0 {
+		return func(*buildRunConfig) {}, nil
+	}
+	mounts, err := parseRunMounts(rawMounts)
+	if err != nil {
+		return nil, fmt.Errorf("RUN --mount: %w", err)
+	}
+	return withMounts(mounts), nil
+}
+
+// defaultShellForOS returns the default shell invocation used to execute
+// `#(nop)` comments and shell-form RUN/CMD/ENTRYPOINT instructions on the
+// given platform.
+func defaultShellForOS(os string) []string {
+	if os == "windows" {
+		return []string{"cmd", "/S", "/C"}
+	}
+	return []string{"/bin/sh", "-c"}
+}
+
+// copyRunConfig returns a buildRunConfig wrapping a deep copy of runConfig,
+// with every modifier applied. runConfig itself is never mutated.
+func copyRunConfig(runConfig *container.Config, modifiers ...runConfigModifier) *buildRunConfig {
+	copied := *runConfig
+	copied.Cmd = copyStringSlice(runConfig.Cmd)
+	copied.Env = copyStringSlice(runConfig.Env)
+	copied.Entrypoint = copyStringSlice(runConfig.Entrypoint)
+	copied.OnBuild = copyStringSlice(runConfig.OnBuild)
+	copied.Shell = copyStringSlice(runConfig.Shell)
+
+	if runConfig.ExposedPorts != nil {
+		copied.ExposedPorts = make(nat.PortSet, len(runConfig.ExposedPorts))
+		for k, v := range runConfig.ExposedPorts {
+			copied.ExposedPorts[k] = v
+		}
+	}
+	if runConfig.Volumes != nil {
+		copied.Volumes = make(map[string]struct{}, len(runConfig.Volumes))
+		for k, v := range runConfig.Volumes {
+			copied.Volumes[k] = v
+		}
+	}
+	if runConfig.Labels != nil {
+		copied.Labels = make(map[string]string, len(runConfig.Labels))
+		for k, v := range runConfig.Labels {
+			copied.Labels[k] = v
+		}
+	}
+
+	rc := &buildRunConfig{Config: &copied}
+	for _, modifier := range modifiers {
+		modifier(rc)
+	}
+	return rc
+}
+
+func copyStringSlice(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	out := make([]string, len(s))
+	copy(out, s)
+	return out
+}
+
+// throttleDeviceFromOptions parses a `<device-path>:<rate>` flag value (as
+// accepted by e.g. --device-read-bps) into a single-element
+// []*blkiodev.ThrottleDevice, or nil if opt isn't in that form.
+func throttleDeviceFromOptions(opt string) []*blkiodev.ThrottleDevice {
+	parts := strings.SplitN(opt, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	rate, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return []*blkiodev.ThrottleDevice{{Path: parts[0], Rate: rate}}
+}
+
+// throttleDeviceFromOptionsWithSize is like throttleDeviceFromOptions but
+// also accepts a size suffix on the rate (e.g. "/dev/sda:20kb"), treating it
+// as a byte count expressed with a kb/mb/gb unit.
+func throttleDeviceFromOptionsWithSize(opt string) []*blkiodev.ThrottleDevice {
+	parts := strings.SplitN(opt, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	rate, err := parseSizeWithUnit(parts[1])
+	if err != nil {
+		return nil
+	}
+	return []*blkiodev.ThrottleDevice{{Path: parts[0], Rate: rate}}
+}
+
+// parseSizeWithUnit parses a byte count with an optional kb/mb/gb suffix
+// (case-insensitive), e.g. "20kb" -> 20480.
+func parseSizeWithUnit(s string) (uint64, error) {
+	units := map[string]uint64{"kb": 1024, "mb": 1024 * 1024, "gb": 1024 * 1024 * 1024}
+	lower := strings.ToLower(s)
+	for suffix, mult := range units {
+		if strings.HasSuffix(lower, suffix) {
+			n, err := strconv.ParseUint(strings.TrimSuffix(lower, suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * mult, nil
+		}
+	}
+	return strconv.ParseUint(lower, 10, 64)
+}
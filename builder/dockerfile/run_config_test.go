@@ -0,0 +1,190 @@
+package dockerfile
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyRunConfig(t *testing.T) {
+	defaultEnv := []string{"foo=1"}
+	defaultCmd := []string{"old"}
+
+	var testcases = []struct {
+		doc       string
+		modifiers []runConfigModifier
+		expected  *buildRunConfig
+	}{
+		{
+			doc:       "Set the command",
+			modifiers: []runConfigModifier{withCmd([]string{"new"})},
+			expected: &buildRunConfig{Config: &container.Config{
+				Cmd: []string{"new"},
+				Env: defaultEnv,
+			}},
+		},
+		{
+			doc:       "Set the command to a comment",
+			modifiers: []runConfigModifier{withCmdComment("comment", runtime.GOOS)},
+			expected: &buildRunConfig{Config: &container.Config{
+				Cmd: append(defaultShellForOS(runtime.GOOS), "#(nop) ", "comment"),
+				Env: defaultEnv,
+			}},
+		},
+		{
+			doc: "Set the command and env",
+			modifiers: []runConfigModifier{
+				withCmd([]string{"new"}),
+				withEnv([]string{"one", "two"}),
+			},
+			expected: &buildRunConfig{Config: &container.Config{
+				Cmd: []string{"new"},
+				Env: []string{"one", "two"},
+			}},
+		},
+		{
+			doc: "Set a bind mount from a prior stage alongside the command",
+			modifiers: []runConfigModifier{
+				withCmd([]string{"go", "build"}),
+				withMounts([]mountRequest{
+					{Type: mountTypeBind, Target: "/src", From: "builder", ReadOnly: true},
+				}),
+			},
+			expected: &buildRunConfig{
+				Config: &container.Config{Cmd: []string{"go", "build"}, Env: defaultEnv},
+				Mounts: []mountRequest{
+					{Type: mountTypeBind, Target: "/src", From: "builder", ReadOnly: true},
+				},
+			},
+		},
+		{
+			doc: "Set a cache mount alongside the command",
+			modifiers: []runConfigModifier{
+				withCmd([]string{"make"}),
+				withMounts([]mountRequest{
+					{Type: mountTypeCache, Target: "/root/.cache", CacheID: "mybuild-cache"},
+				}),
+			},
+			expected: &buildRunConfig{
+				Config: &container.Config{Cmd: []string{"make"}, Env: defaultEnv},
+				Mounts: []mountRequest{
+					{Type: mountTypeCache, Target: "/root/.cache", CacheID: "mybuild-cache"},
+				},
+			},
+		},
+	}
+
+	for _, testcase := range testcases {
+		runConfig := &container.Config{
+			Cmd: defaultCmd,
+			Env: defaultEnv,
+		}
+		runConfigCopy := copyRunConfig(runConfig, testcase.modifiers...)
+		assert.Equal(t, testcase.expected, runConfigCopy, testcase.doc)
+		// Assert the original was not modified
+		assert.NotEqual(t, runConfig, runConfigCopy.Config, testcase.doc)
+	}
+
+}
+
+func TestRunMountsModifierParsesFlagsOntoRunConfig(t *testing.T) {
+	runConfig := &container.Config{Cmd: []string{"old"}, Env: []string{"foo=1"}}
+
+	modifier, err := runMountsModifier([]string{"type=bind,from=builder,source=/out,target=/out"})
+	require.NoError(t, err)
+
+	rc := copyRunConfig(runConfig, withCmd([]string{"go", "build"}), modifier)
+	assert.Equal(t, []mountRequest{
+		{Type: mountTypeBind, Target: "/out", Source: "/out", From: "builder", ReadOnly: true},
+	}, rc.Mounts)
+}
+
+func TestRunMountsModifierRejectsInvalidFlag(t *testing.T) {
+	_, err := runMountsModifier([]string{"type=bogus,target=/out"})
+	assert.Error(t, err)
+}
+
+func TestRunMountsModifierEmptyIsNoop(t *testing.T) {
+	modifier, err := runMountsModifier(nil)
+	require.NoError(t, err)
+
+	rc := copyRunConfig(&container.Config{Cmd: []string{"old"}}, modifier)
+	assert.Nil(t, rc.Mounts)
+}
+
+func TestThrottleDeviceFromOptionsWithSize(t *testing.T) {
+	opt := "/dev/sda:20kb"
+	expectedThrottleDevice := blkiodev.ThrottleDevice{
+		Path: "/dev/sda",
+		Rate: 20480,
+	}
+	throttleDevice := throttleDeviceFromOptionsWithSize(opt)
+	assert.Equal(t, expectedThrottleDevice, *throttleDevice[0], "correctly get throttleDevice from options with size")
+
+	// test for invalid option
+	opt = "/no/rate:kb"
+	nilDevice := []*blkiodev.ThrottleDevice([]*blkiodev.ThrottleDevice(nil))
+	throttleDevice = throttleDeviceFromOptionsWithSize(opt)
+	assert.Equal(t, nilDevice, throttleDevice, "return nil for invalid options")
+	assert.Equal(t, 0, len(throttleDevice), "length of invalid device should be zero")
+}
+
+func TestThrottleDeviceFromOptions(t *testing.T) {
+	opt := "/dev/sda:500"
+	expectedThrottleDevice := blkiodev.ThrottleDevice{
+		Path: "/dev/sda",
+		Rate: 500,
+	}
+	throttleDevice := throttleDeviceFromOptions(opt)
+	assert.Equal(t, expectedThrottleDevice, *throttleDevice[0], "correctly get throttleDevice from options")
+
+	// test for invalid option
+	opt = "/no/rate"
+	nilDevice := []*blkiodev.ThrottleDevice([]*blkiodev.ThrottleDevice(nil))
+	throttleDevice = throttleDeviceFromOptions(opt)
+	assert.Equal(t, nilDevice, throttleDevice, "return nil for invalid options")
+	assert.Equal(t, 0, len(throttleDevice), "length of invalid device should be zero")
+}
+
+func fullMutableRunConfig() *container.Config {
+	return &container.Config{
+		Cmd: []string{"command", "arg1"},
+		Env: []string{"env1=foo", "env2=bar"},
+		ExposedPorts: nat.PortSet{
+			"1000/tcp": {},
+			"1001/tcp": {},
+		},
+		Volumes: map[string]struct{}{
+			"one": {},
+			"two": {},
+		},
+		Entrypoint: []string{"entry", "arg1"},
+		OnBuild:    []string{"first", "next"},
+		Labels: map[string]string{
+			"label1": "value1",
+			"label2": "value2",
+		},
+		Shell: []string{"shell", "-c"},
+	}
+}
+
+func TestDeepCopyRunConfig(t *testing.T) {
+	runConfig := fullMutableRunConfig()
+	copy := copyRunConfig(runConfig).Config
+	assert.Equal(t, fullMutableRunConfig(), copy)
+
+	copy.Cmd[1] = "arg2"
+	copy.Env[1] = "env2=new"
+	copy.ExposedPorts["10002"] = struct{}{}
+	copy.Volumes["three"] = struct{}{}
+	copy.Entrypoint[1] = "arg2"
+	copy.OnBuild[0] = "start"
+	copy.Labels["label3"] = "value3"
+	copy.Shell[0] = "sh"
+	assert.Equal(t, fullMutableRunConfig(), runConfig)
+}
@@ -0,0 +1,172 @@
+package dockerfile // import "github.com/docker/docker/builder/dockerfile"
+
+import (
+	"fmt"
+	"strings"
+
+	mounttypes "github.com/docker/docker/api/types/mount"
+)
+
+// mountType is the `type=` value of a RUN --mount flag.
+type mountType string
+
+const (
+	mountTypeBind   mountType = "bind"
+	mountTypeCache  mountType = "cache"
+	mountTypeTmpfs  mountType = "tmpfs"
+	mountTypeSecret mountType = "secret"
+	mountTypeSSH    mountType = "ssh"
+)
+
+// mountRequest is the parsed form of a single `--mount=type=...,...` flag on
+// a RUN instruction. Which fields are meaningful depends on Type:
+//
+//   - bind:   From names the prior stage whose rootfs is bind-mounted at
+//             Target (From == "" means the build context). Source is the
+//             path within that rootfs to mount ("/" if unset). The mount is
+//             read-only by default; readwrite/rw makes a build-context bind
+//             writable, while a prior stage's rootfs is always mounted
+//             read-only.
+//   - cache:  CacheID (id=, or Target if unset) names the backing volume
+//             mounted at Target. It is scoped to this build: steps of the
+//             same build share it, other builds do not.
+//   - tmpfs:  only Target is used; an anonymous tmpfs is mounted there.
+//   - secret: ID names a daemon-side secret bound read-only at Target (or
+//             /run/secrets/<ID> if Target is empty). The mount carries the
+//             permissions of the staged file; no mode is applied to it here.
+//   - ssh:    ID names a daemon-side SSH agent socket/key forwarded at
+//             Target (or /run/buildkit/ssh_agent.0 if Target is empty).
+type mountRequest struct {
+	Type     mountType
+	Target   string
+	Source   string
+	From     string
+	CacheID  string
+	ID       string
+	ReadOnly bool
+}
+
+// parseRunMounts parses every `--mount=` flag attached to a single RUN
+// instruction into the mount requests the run dispatcher must materialize
+// before the step's container starts.
+func parseRunMounts(values []string) ([]mountRequest, error) {
+	mounts := make([]mountRequest, 0, len(values))
+	for _, v := range values {
+		m, err := parseRunMount(v)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// parseRunMount parses a single `--mount=key=value,key=value,...` flag
+// value, as it appears after the `--mount=` prefix has already been
+// stripped by the flag parser.
+func parseRunMount(value string) (mountRequest, error) {
+	m := mountRequest{Type: mountTypeBind, ReadOnly: true}
+	readOnlySet := false
+
+	for _, field := range strings.Split(value, ",") {
+		if field == "" {
+			continue
+		}
+		key, val, hasVal := strings.Cut(field, "=")
+		switch key {
+		case "type":
+			m.Type = mountType(val)
+		case "target", "dst", "destination":
+			m.Target = val
+		case "from":
+			m.From = val
+		case "source", "src":
+			m.Source = val
+		case "id":
+			m.ID = val
+		case "sharing":
+			// accepted for BuildKit flag compatibility; the classic
+			// builder always scopes a cache mount to the current build,
+			// so there's no "shared"/"locked" distinction to honor.
+		case "readonly", "ro":
+			m.ReadOnly = !hasVal || val == "" || val == "true"
+			readOnlySet = true
+		case "readwrite", "rw":
+			m.ReadOnly = hasVal && (val == "false")
+			readOnlySet = true
+		default:
+			return mountRequest{}, fmt.Errorf("unsupported mount flag %q", key)
+		}
+	}
+
+	switch m.Type {
+	case mountTypeBind:
+	case mountTypeCache:
+		m.CacheID = m.ID
+		if m.CacheID == "" {
+			m.CacheID = m.Target
+		}
+		// Cache mounts default to read-write, unlike every other type; only
+		// apply that default when the caller didn't explicitly set
+		// readonly/rw themselves.
+		if !readOnlySet {
+			m.ReadOnly = false
+		}
+	case mountTypeTmpfs:
+	case mountTypeSecret:
+		m.ReadOnly = true
+		if m.Target == "" {
+			m.Target = "/run/secrets/" + m.ID
+		}
+	case mountTypeSSH:
+		m.ReadOnly = true
+		if m.Target == "" {
+			m.Target = "/run/buildkit/ssh_agent.0"
+		}
+	default:
+		return mountRequest{}, fmt.Errorf("invalid mount type %q", m.Type)
+	}
+
+	if m.Target == "" {
+		return mountRequest{}, fmt.Errorf("invalid mount specifier %q: target is required", value)
+	}
+
+	return m, nil
+}
+
+// cacheMountKey scopes a cache mount's backing volume name to the build it
+// belongs to, so two different builds using the same CacheID don't share a
+// volume.
+func cacheMountKey(buildID string, m mountRequest) string {
+	return fmt.Sprintf("buildkit-cache-%s-%s", buildID, m.CacheID)
+}
+
+// toAPIMount translates a resolved mountRequest into the api/types/mount
+// representation attached to the step container's HostConfig. source is the
+// host path (or volume name, for type=cache) the run dispatcher already
+// resolved for m: a prior stage's rootfs for type=bind, a build-scoped
+// volume for type=cache, or a daemon-side secret/SSH staging path for
+// type=secret/ssh. type=tmpfs needs no source.
+func toAPIMount(m mountRequest, source string) mounttypes.Mount {
+	switch m.Type {
+	case mountTypeTmpfs:
+		return mounttypes.Mount{
+			Type:   mounttypes.TypeTmpfs,
+			Target: m.Target,
+		}
+	case mountTypeCache:
+		return mounttypes.Mount{
+			Type:     mounttypes.TypeVolume,
+			Source:   source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+	default: // bind, secret, ssh
+		return mounttypes.Mount{
+			Type:     mounttypes.TypeBind,
+			Source:   source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+	}
+}
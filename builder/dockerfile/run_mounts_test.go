@@ -0,0 +1,83 @@
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunMounts(t *testing.T) {
+	for _, testcase := range []struct {
+		name     string
+		value    string
+		expected mountRequest
+	}{
+		{
+			name:     "CacheDefault",
+			value:    "type=cache,target=/root/.cache",
+			expected: mountRequest{Type: mountTypeCache, Target: "/root/.cache", CacheID: "/root/.cache"},
+		},
+		{
+			name:     "CacheWithID",
+			value:    "type=cache,target=/root/.cache,id=mybuild",
+			expected: mountRequest{Type: mountTypeCache, Target: "/root/.cache", ID: "mybuild", CacheID: "mybuild"},
+		},
+		{
+			name:     "CacheExplicitReadOnlyIsHonored",
+			value:    "type=cache,target=/root/.cache,readonly=true",
+			expected: mountRequest{Type: mountTypeCache, Target: "/root/.cache", CacheID: "/root/.cache", ReadOnly: true},
+		},
+		{
+			name:     "BindFromStage",
+			value:    "type=bind,from=builder,source=/out,target=/out",
+			expected: mountRequest{Type: mountTypeBind, Target: "/out", Source: "/out", From: "builder", ReadOnly: true},
+		},
+		{
+			name:     "BindReadWrite",
+			value:    "type=bind,target=/data,readwrite=true",
+			expected: mountRequest{Type: mountTypeBind, Target: "/data", ReadOnly: false},
+		},
+		{
+			name:     "Tmpfs",
+			value:    "type=tmpfs,target=/tmp",
+			expected: mountRequest{Type: mountTypeTmpfs, Target: "/tmp"},
+		},
+		{
+			name:     "Secret",
+			value:    "type=secret,id=mysecret,target=/run/secrets/mysecret",
+			expected: mountRequest{Type: mountTypeSecret, Target: "/run/secrets/mysecret", ID: "mysecret", ReadOnly: true},
+		},
+		{
+			name:     "SSH",
+			value:    "type=ssh,id=default",
+			expected: mountRequest{Type: mountTypeSSH, Target: "/run/buildkit/ssh_agent.0", ID: "default", ReadOnly: true},
+		},
+		{
+			name:     "DefaultTypeIsBind",
+			value:    "target=/out",
+			expected: mountRequest{Type: mountTypeBind, Target: "/out", ReadOnly: true},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			m, err := parseRunMount(testcase.value)
+			require.NoError(t, err)
+			assert.Equal(t, testcase.expected, m)
+		})
+	}
+
+	// error cases
+	for _, testcase := range []struct {
+		name  string
+		value string
+	}{
+		{name: "MissingTarget", value: "type=cache"},
+		{name: "UnknownType", value: "type=bogus,target=/out"},
+		{name: "UnknownFlag", value: "type=bind,target=/out,bogus=1"},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			_, err := parseRunMount(testcase.value)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package daemon // import "github.com/docker/docker/daemon"
+
+import (
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/container"
+	"github.com/docker/docker/pkg/copy"
+)
+
+// containerCopyRoot builds the pkg/copy.Root describing c's filesystem: its
+// rootfs, its attached mounts/volumes (so a path landing inside one of them
+// resolves onto the mount's real source instead of the rootfs underneath
+// it), and the daemon's id mapping for userns-remapped containers.
+func (daemon *Daemon) containerCopyRoot(c *container.Container) copy.Root {
+	mounts := make(copy.MountPoints, 0, len(c.MountPoints))
+	for _, mp := range c.MountPoints {
+		mounts = append(mounts, copy.Mount{Destination: mp.Destination, Source: mp.Source})
+	}
+	return copy.Root{
+		BaseFS:     c.BaseFS,
+		Mounts:     mounts,
+		IDMappings: daemon.idMapping,
+	}
+}
+
+// ContainerStatPath stats path inside container name, resolving it against
+// the container's mounts the same way ContainerArchivePath does.
+func (daemon *Daemon) ContainerStatPath(name, path string) (*types.ContainerPathStat, error) {
+	c, err := daemon.GetContainer(name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, stat, err := copy.Get(daemon.containerCopyRoot(c), path)
+	if err != nil {
+		return nil, err
+	}
+	return toAPIPathStat(stat), nil
+}
+
+// ContainerArchivePath implements the GET side of the
+// `/containers/{id}/archive` endpoint: it returns a tar stream of path
+// inside container name along with a stat of what was read.
+func (daemon *Daemon) ContainerArchivePath(name, path string) (io.ReadCloser, *types.ContainerPathStat, error) {
+	c, err := daemon.GetContainer(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tarStream, stat, err := copy.Get(daemon.containerCopyRoot(c), path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tarStream, toAPIPathStat(stat), nil
+}
+
+// ContainerExtractToDir implements the PUT side of the
+// `/containers/{id}/archive` endpoint: it extracts the tar stream in
+// content onto path inside container name.
+func (daemon *Daemon) ContainerExtractToDir(name, path string, allowOverwriteDirWithFile bool, content io.Reader) error {
+	c, err := daemon.GetContainer(name)
+	if err != nil {
+		return err
+	}
+
+	return copy.Put(daemon.containerCopyRoot(c), path, content, copy.PutOptions{
+		AllowOverwriteDirWithFile: allowOverwriteDirWithFile,
+	})
+}
+
+func toAPIPathStat(stat copy.Stat) *types.ContainerPathStat {
+	return &types.ContainerPathStat{
+		Name:       stat.Name,
+		Size:       stat.Size,
+		Mode:       stat.Mode,
+		Mtime:      stat.Mtime,
+		LinkTarget: stat.LinkTarget,
+	}
+}
@@ -0,0 +1,191 @@
+package daemon // import "github.com/docker/docker/daemon"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	mounttypes "github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/container"
+	"github.com/docker/docker/volume"
+)
+
+// Typed subpath errors returned by resolveVolumeSubpath. Callers (and tests)
+// distinguish them to tell a typo in the Dockerfile/compose file apart from
+// an attempt to read outside the volume.
+var (
+	// errSubpathNotExist is returned when the requested subpath does not
+	// exist inside the volume yet.
+	errSubpathNotExist = errors.New("subpath does not exist yet")
+	// errSubpathEscapesVolume is returned when the requested subpath, once
+	// every symlink component is resolved, would land outside the volume's
+	// data directory.
+	errSubpathEscapesVolume = errors.New("subpath escapes volume")
+	// errSubpathNotADirectory is returned when the requested subpath
+	// resolves to something other than a directory.
+	errSubpathNotADirectory = errors.New("subpath is not a directory")
+)
+
+// registerMountPoints validates hostConfig.Mounts and attaches them to
+// container. A mount's VolumeOptions.Subpath can only be resolved against
+// the volume's actual data directory once that volume exists, which for a
+// freshly named volume isn't guaranteed yet at registration time — so here
+// we only reject subpaths that are lexically invalid (absolute, or
+// containing `..`). The disk-backed, symlink-aware resolution against the
+// volume's data directory happens in setupMounts, called again immediately
+// before the mount is realized so a path swapped out from under us between
+// registration and realization is still caught.
+func (daemon *Daemon) registerMountPoints(container *container.Container, hostConfig *containertypes.HostConfig) error {
+	if container.MountPoints == nil {
+		container.MountPoints = make(map[string]*volume.MountPoint)
+	}
+	for _, m := range hostConfig.Mounts {
+		mp, err := daemon.newMountPointFromMount(m)
+		if err != nil {
+			return err
+		}
+
+		if m.VolumeOptions != nil && m.VolumeOptions.Subpath != "" {
+			if err := validateSubpathSyntax(m.VolumeOptions.Subpath); err != nil {
+				return fmt.Errorf("invalid subpath %q for mount %q: %w", m.VolumeOptions.Subpath, m.Target, err)
+			}
+		}
+
+		container.MountPoints[mp.Destination] = mp
+	}
+	return nil
+}
+
+// validateSubpathSyntax rejects a subpath that is absolute or contains a
+// `..` component without touching the filesystem. It exists so obviously
+// malformed subpaths are rejected at registration time, before a volume
+// backing the mount necessarily exists.
+func validateSubpathSyntax(subpath string) error {
+	if filepath.IsAbs(subpath) {
+		return errSubpathEscapesVolume
+	}
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(subpath)), "/") {
+		if part == ".." {
+			return errSubpathEscapesVolume
+		}
+	}
+	return nil
+}
+
+// newMountPointFromMount turns an API-level mount.Mount into the volume
+// package's internal MountPoint representation. It is deliberately minimal:
+// driver resolution and volume creation are handled elsewhere, this only
+// needs enough of the mount point to let registerMountPoints validate and
+// record a subpath. Spec carries the original mount through to setupMounts,
+// which is what actually resolves m.VolumeOptions.Subpath against the
+// volume's data directory.
+func (daemon *Daemon) newMountPointFromMount(m mounttypes.Mount) (*volume.MountPoint, error) {
+	return &volume.MountPoint{
+		Type:        m.Type,
+		Source:      m.Source,
+		Destination: m.Target,
+		ReadOnly:    m.ReadOnly,
+		Spec:        m,
+	}, nil
+}
+
+// resolveVolumeSubpath resolves subpath (a path given by the user, relative
+// to a volume's root) against that volume's data directory, refusing
+// absolute components, `..` traversal, and symlinks that would resolve
+// outside dataDir. An absolute symlink target is taken as the host path it
+// names. It returns the resolved, absolute host path.
+//
+// Callers that need TOCTOU safety (re-checking immediately before the mount
+// is bound into the container, after the lexical check already ran once at
+// registration) should use resolveVolumeSubpathTOCTOUSafe instead, which
+// re-opens every path component with O_NOFOLLOW rather than trusting this
+// lexical walk a second time.
+func resolveVolumeSubpath(dataDir, subpath string) (string, error) {
+	if filepath.IsAbs(subpath) {
+		return "", errSubpathEscapesVolume
+	}
+
+	resolved := dataDir
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(subpath)), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", errSubpathEscapesVolume
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(next)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", errSubpathNotExist
+			}
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(next)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(next), target)
+			}
+			if !isWithin(dataDir, target) {
+				return "", errSubpathEscapesVolume
+			}
+			next = target
+		}
+
+		resolved = next
+	}
+
+	if !isWithin(dataDir, resolved) {
+		return "", errSubpathEscapesVolume
+	}
+
+	// The walk above follows one link per component; a link whose target is
+	// itself a link, or passes through one, is checked here against where it
+	// really leads.
+	realRoot, err := filepath.EvalSymlinks(dataDir)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(resolved)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errSubpathNotExist
+		}
+		return "", err
+	}
+	if !isWithin(realRoot, realPath) {
+		return "", errSubpathEscapesVolume
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errSubpathNotExist
+		}
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errSubpathNotADirectory
+	}
+
+	return resolved, nil
+}
+
+// isWithin reports whether candidate is root or a descendant of root,
+// lexically. Both paths are expected to already be absolute and cleaned.
+func isWithin(root, candidate string) bool {
+	root = filepath.Clean(root)
+	candidate = filepath.Clean(candidate)
+	if candidate == root {
+		return true
+	}
+	return strings.HasPrefix(candidate, root+string(os.PathSeparator))
+}
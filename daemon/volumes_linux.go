@@ -0,0 +1,173 @@
+package daemon // import "github.com/docker/docker/daemon"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/docker/docker/container"
+	"github.com/docker/docker/volume"
+)
+
+// resolvedMount is one of container's MountPoints together with the host
+// path it should actually be bound from. For a mount without a Subpath that
+// is just MountPoint.Source; for one with a Subpath it is the subpath,
+// resolved and TOCTOU-checked against that source by setupMounts.
+type resolvedMount struct {
+	*volume.MountPoint
+	Source string
+}
+
+// setupMounts resolves every mount point registered on container into its
+// final host-side source path, immediately before those mounts are bound
+// into the container. registerMountPoints only checked a Subpath lexically,
+// since the volume backing it may not have existed yet; this is where that
+// subpath actually gets resolved against the volume's real data directory,
+// as close as possible to the bind happening so a path swapped out from
+// under us in between is still caught.
+func (daemon *Daemon) setupMounts(c *container.Container) ([]resolvedMount, error) {
+	mounts := make([]resolvedMount, 0, len(c.MountPoints))
+	for _, mp := range c.MountPoints {
+		source := mp.Source
+		if mp.Spec.VolumeOptions != nil && mp.Spec.VolumeOptions.Subpath != "" {
+			resolved, err := resolveVolumeSubpathTOCTOUSafe(mp.Source, mp.Spec.VolumeOptions.Subpath)
+			if err != nil {
+				return nil, fmt.Errorf("resolving subpath for mount %q: %w", mp.Destination, err)
+			}
+			source = resolved
+		}
+		mounts = append(mounts, resolvedMount{MountPoint: mp, Source: source})
+	}
+	return mounts, nil
+}
+
+// resolveVolumeSubpathTOCTOUSafe re-validates a subpath that registerMountPoints
+// accepted at registration time (a lexical check only, via
+// validateSubpathSyntax), immediately before the mount is realized. Between those two points a malicious or compromised
+// container could have swapped a path component for a symlink pointing
+// outside the volume; re-opening every component with O_NOFOLLOW (or, when
+// the kernel supports it, a single openat2 RESOLVE_BENEATH) closes that
+// window instead of trusting the earlier lexical resolution.
+func resolveVolumeSubpathTOCTOUSafe(dataDir, subpath string) (string, error) {
+	if fd, err := openBeneath(dataDir, subpath); err == nil {
+		defer unix.Close(fd)
+		var st unix.Stat_t
+		if err := unix.Fstat(fd, &st); err != nil {
+			return "", err
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+			return "", errSubpathNotADirectory
+		}
+		return resolveFdPath(fd)
+	} else if !errors.Is(err, unix.ENOSYS) {
+		return "", translateOpenBeneathErr(err)
+	}
+
+	// openat2/RESOLVE_BENEATH isn't supported by this kernel; fall back to
+	// opening each path component with O_NOFOLLOW so a symlink swapped in
+	// mid-walk is rejected rather than followed.
+	dirFd, err := unix.Open(dataDir, unix.O_DIRECTORY|unix.O_PATH, 0)
+	if err != nil {
+		return "", fmt.Errorf("opening volume data dir: %w", err)
+	}
+	defer unix.Close(dirFd)
+
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(subpath)), "/")
+	cur := dirFd
+	closeCur := false
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			return "", errSubpathEscapesVolume
+		}
+
+		flags := unix.O_PATH | unix.O_NOFOLLOW
+		last := i == len(parts)-1
+		if !last {
+			flags |= unix.O_DIRECTORY
+		}
+		next, err := unix.Openat(cur, part, flags, 0)
+		if closeCur {
+			unix.Close(cur)
+		}
+		if err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				return "", errSubpathNotExist
+			}
+			if errors.Is(err, unix.ELOOP) {
+				return "", errSubpathEscapesVolume
+			}
+			return "", err
+		}
+		cur, closeCur = next, true
+	}
+	defer func() {
+		if closeCur {
+			unix.Close(cur)
+		}
+	}()
+
+	// O_PATH|O_NOFOLLOW opens a symlink in the last component as the link
+	// itself instead of failing, so it is rejected here rather than followed.
+	var st unix.Stat_t
+	if err := unix.Fstat(cur, &st); err != nil {
+		return "", err
+	}
+	if st.Mode&unix.S_IFMT == unix.S_IFLNK {
+		return "", errSubpathEscapesVolume
+	}
+
+	resolved, err := resolveFdPath(cur)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(dataDir, resolved) {
+		return "", errSubpathEscapesVolume
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errSubpathNotADirectory
+	}
+	return resolved, nil
+}
+
+// openBeneath wraps openat2(2) with RESOLVE_BENEATH, returning unix.ENOSYS
+// when the running kernel doesn't implement openat2 so callers can fall
+// back to the per-component O_NOFOLLOW walk.
+func openBeneath(dataDir, subpath string) (int, error) {
+	dirFd, err := unix.Open(dataDir, unix.O_DIRECTORY|unix.O_PATH, 0)
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(dirFd)
+
+	return unix.Openat2(dirFd, subpath, &unix.OpenHow{
+		Flags:   unix.O_PATH,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+	})
+}
+
+func resolveFdPath(fd int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+}
+
+func translateOpenBeneathErr(err error) error {
+	switch {
+	case errors.Is(err, unix.ENOENT):
+		return errSubpathNotExist
+	case errors.Is(err, unix.EXDEV), errors.Is(err, unix.ELOOP):
+		return errSubpathEscapesVolume
+	default:
+		return err
+	}
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/container"
+	"github.com/docker/docker/volume"
+)
+
+func TestSetupMountsResolvesSubpath(t *testing.T) {
+	dataDir := t.TempDir()
+	mustMkdir(t, filepath.Join(dataDir, "sub"))
+
+	d := Daemon{containers: container.NewMemoryStore()}
+	c := &container.Container{
+		MountPoints: map[string]*volume.MountPoint{
+			"/data": {
+				Type:        mount.TypeVolume,
+				Source:      dataDir,
+				Destination: "/data",
+				Spec: mount.Mount{
+					VolumeOptions: &mount.VolumeOptions{Subpath: "sub"},
+				},
+			},
+		},
+	}
+
+	mounts, err := d.setupMounts(c)
+	if err != nil {
+		t.Fatalf("setupMounts: %v", err)
+	}
+	if len(mounts) != 1 {
+		t.Fatalf("expected 1 resolved mount, got %d", len(mounts))
+	}
+	if mounts[0].Source != filepath.Join(dataDir, "sub") {
+		t.Fatalf("expected source %q, got %q", filepath.Join(dataDir, "sub"), mounts[0].Source)
+	}
+}
+
+func TestSetupMountsRejectsEscapingSubpath(t *testing.T) {
+	dataDir := t.TempDir()
+	outside := t.TempDir()
+	mustSymlink(t, outside, filepath.Join(dataDir, "escape-link"))
+
+	d := Daemon{containers: container.NewMemoryStore()}
+	c := &container.Container{
+		MountPoints: map[string]*volume.MountPoint{
+			"/data": {
+				Type:        mount.TypeVolume,
+				Source:      dataDir,
+				Destination: "/data",
+				Spec: mount.Mount{
+					VolumeOptions: &mount.VolumeOptions{Subpath: "escape-link"},
+				},
+			},
+		},
+	}
+
+	if _, err := d.setupMounts(c); err == nil {
+		t.Fatal("expected an error for a subpath escaping the volume")
+	}
+}
@@ -1,6 +1,9 @@
 package daemon
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -71,3 +74,90 @@ func TestMountSubdir(t *testing.T) {
 		t.Fatalf("unexpected error")
 	}
 }
+
+func TestRegisterMountPointsRejectsEscapingSubpath(t *testing.T) {
+	d := Daemon{containers: container.NewMemoryStore()}
+
+	for _, subpath := range []string{"/etc", "../escape", "a/../../escape"} {
+		mountSubdir := mount.Mount{
+			mount.TypeVolume,
+			"check",
+			"check2",
+			false,
+			mount.ConsistencyDelegated,
+			nil,
+			&mount.VolumeOptions{Subpath: subpath},
+			nil,
+		}
+		c := container.Container{}
+		hostConfig := containertypes.HostConfig{Mounts: []mount.Mount{mountSubdir}}
+
+		if err := d.registerMountPoints(&c, &hostConfig); err == nil {
+			t.Fatalf("expected subpath %q to be rejected at registration", subpath)
+		}
+	}
+}
+
+func TestResolveVolumeSubpath(t *testing.T) {
+	dataDir := t.TempDir()
+
+	mustMkdir(t, filepath.Join(dataDir, "good"))
+	mustMkdir(t, filepath.Join(dataDir, "good", "nested"))
+	mustWriteFile(t, filepath.Join(dataDir, "afile"))
+
+	outside := t.TempDir()
+	mustMkdir(t, filepath.Join(outside, "secret"))
+
+	mustSymlink(t, outside, filepath.Join(dataDir, "escape-link"))
+	mustSymlink(t, filepath.Join("..", "..", filepath.Base(outside)), filepath.Join(dataDir, "good", "nested-escape"))
+
+	for _, tc := range []struct {
+		name    string
+		subpath string
+		wantErr error
+	}{
+		{name: "exists", subpath: "good", wantErr: nil},
+		{name: "nested exists", subpath: filepath.Join("good", "nested"), wantErr: nil},
+		{name: "missing", subpath: "does-not-exist", wantErr: errSubpathNotExist},
+		{name: "not a directory", subpath: "afile", wantErr: errSubpathNotADirectory},
+		{name: "direct symlink escape", subpath: "escape-link", wantErr: errSubpathEscapesVolume},
+		{name: "nested symlink escape", subpath: filepath.Join("good", "nested-escape"), wantErr: errSubpathEscapesVolume},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := resolveVolumeSubpath(dataDir, tc.subpath)
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !filepath.IsAbs(resolved) {
+					t.Fatalf("expected an absolute resolved path, got %q", resolved)
+				}
+				return
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func mustMkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatalf("failed to create dir %q: %v", path, err)
+	}
+}
+
+func mustWriteFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to write file %q: %v", path, err)
+	}
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("failed to create symlink %q -> %q: %v", link, target, err)
+	}
+}
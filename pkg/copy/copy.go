@@ -0,0 +1,223 @@
+// Package copy implements the get/put primitives behind
+// `docker cp` and the `/containers/{id}/archive` endpoint.
+//
+// It is loosely modeled on Buildah's copier: a path given by the caller is
+// always interpreted relative to the container's view of its filesystem,
+// which means it has to be resolved against both the container's rootfs and
+// whichever mounts and volumes are attached on top of it before any I/O
+// happens. Resolving against the rootfs alone would silently read from, or
+// write into, whatever happened to be on disk underneath a mount instead of
+// the mount itself.
+package copy // import "github.com/docker/docker/pkg/copy"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/docker/docker/pkg/symlink"
+)
+
+// ErrNotExist is returned by Get and Put when the resolved path does not
+// exist. Put never creates the destination's parent on the caller's behalf:
+// a missing destination is treated as a mistake to surface, not a directory
+// to create.
+var ErrNotExist = errors.New("no such file or directory")
+
+// Mount describes a single mount or volume attached to a container, in
+// container-relative terms. Destination is the path inside the container
+// the mount is attached at; Source is the corresponding path on the host
+// (or, for userns-remapped daemons, the path as seen before id-remapping).
+type Mount struct {
+	Destination string
+	Source      string
+}
+
+// MountPoints is the set of mounts/volumes attached to a container, used to
+// resolve a container-relative path onto the host.
+type MountPoints []Mount
+
+// Resolve finds the most specific mount whose Destination contains
+// containerPath and rewrites containerPath onto that mount's Source. The
+// second return value is false when no mount matches, meaning the path
+// should be resolved against the container's rootfs instead.
+func (m MountPoints) Resolve(containerPath string) (hostPath string, rel string, ok bool) {
+	containerPath = filepath.Clean(string(os.PathSeparator) + containerPath)
+
+	var best Mount
+	bestLen := -1
+	for _, mnt := range m {
+		dest := filepath.Clean(string(os.PathSeparator) + mnt.Destination)
+		if containerPath != dest && !strings.HasPrefix(containerPath, dest+string(os.PathSeparator)) {
+			continue
+		}
+		if len(dest) > bestLen {
+			best, bestLen = mnt, len(dest)
+		}
+	}
+	if bestLen == -1 {
+		return "", "", false
+	}
+
+	dest := filepath.Clean(string(os.PathSeparator) + best.Destination)
+	rel, err := filepath.Rel(dest, containerPath)
+	if err != nil {
+		return "", "", false
+	}
+	return best.Source, rel, true
+}
+
+// Stat describes the resolved endpoint of a Get or Put, mirroring what
+// `docker cp` reports to the client via the X-Docker-Container-Path-Stat
+// header.
+type Stat struct {
+	Name       string
+	Size       int64
+	Mode       os.FileMode
+	Mtime      time.Time
+	LinkTarget string
+}
+
+// Root pins down the two things every resolution needs: the container's
+// rootfs on the host, and its attached mounts/volumes. IDMappings is the
+// uid/gid mapping of a userns-remapped daemon; it is the zero value for
+// daemons that don't remap.
+type Root struct {
+	BaseFS     string
+	Mounts     MountPoints
+	IDMappings *idtools.IDMappings
+}
+
+// resolve maps a container-relative path onto a host path, preferring a
+// mount/volume match over the rootfs and refusing to let symlinks escape
+// whichever root it resolved against.
+func (r Root) resolve(containerPath string) (string, error) {
+	if hostPath, rel, ok := r.Mounts.Resolve(containerPath); ok {
+		return symlink.FollowSymlinkInScope(filepath.Join(hostPath, rel), hostPath)
+	}
+	return symlink.FollowSymlinkInScope(filepath.Join(r.BaseFS, containerPath), r.BaseFS)
+}
+
+// Get resolves path against root and returns a tar stream of it (a single
+// file is wrapped in a one-entry tar, matching the `/archive` endpoint's
+// contract) along with a Stat describing what was read.
+func Get(root Root, path string) (io.ReadCloser, Stat, error) {
+	resolved, err := root.resolve(path)
+	if err != nil {
+		return nil, Stat{}, fmt.Errorf("copy: resolving %s: %w", path, err)
+	}
+
+	lstat, err := os.Lstat(resolved)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, Stat{}, fmt.Errorf("copy: %s: %w", path, ErrNotExist)
+		}
+		return nil, Stat{}, err
+	}
+
+	stat := Stat{
+		Name:  filepath.Base(resolved),
+		Size:  lstat.Size(),
+		Mode:  lstat.Mode(),
+		Mtime: lstat.ModTime(),
+	}
+	if lstat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(resolved)
+		if err != nil {
+			return nil, Stat{}, err
+		}
+		stat.LinkTarget = target
+	}
+
+	srcDir, srcBase := filepath.Split(resolved)
+	uidMaps, gidMaps := idMapsOrEmpty(root.IDMappings)
+	tarStream, err := archive.TarWithOptions(srcDir, &archive.TarOptions{
+		IncludeFiles: []string{srcBase},
+		UIDMaps:      uidMaps,
+		GIDMaps:      gidMaps,
+		RebaseNames:  map[string]string{srcBase: stat.Name},
+	})
+	if err != nil {
+		return nil, Stat{}, fmt.Errorf("copy: taring %s: %w", path, err)
+	}
+	return tarStream, stat, nil
+}
+
+// PutOptions controls how Put lays a tar stream down on top of an already
+// resolved destination.
+type PutOptions struct {
+	// AllowOverwriteDirWithFile permits a tar entry that is a regular file
+	// to replace an existing directory destination, matching the classic
+	// `docker cp` behavior when the destination doesn't end in a path
+	// separator.
+	AllowOverwriteDirWithFile bool
+}
+
+// Put resolves dest against root and extracts the tar stream onto it. Put
+// never creates a missing parent directory on the caller's behalf: when
+// dest's parent does not exist it fails with ErrNotExist rather than
+// silently creating a path that may have been mistyped. A dest that does not
+// exist inside an existing parent is not an error; the tar stream is then
+// extracted into that parent.
+//
+// dest is resolved directly first, exactly like Get resolves its path — not
+// by resolving dest's parent and rejoining dest's base name onto it. A
+// mount's Destination can be mounted anywhere in the container, including
+// exactly at dest (e.g. `docker cp file ctr:/data` where /data is itself a
+// bind or volume mount); resolving only the parent would land on
+// filepath.Dir(dest) in that case, which MountPoints.Resolve has no reason
+// to match, silently falling back to the rootfs underneath the mount
+// instead of the mount's real source.
+func Put(root Root, dest string, tarStream io.Reader, opts PutOptions) error {
+	if resolved, err := root.resolve(dest); err == nil {
+		if info, statErr := os.Lstat(resolved); statErr == nil {
+			if info.IsDir() {
+				return untar(root, tarStream, resolved, opts)
+			}
+			if !opts.AllowOverwriteDirWithFile {
+				// dest already exists and is not a directory: extract into
+				// its parent so the tar entry replaces it in place.
+				return untar(root, tarStream, filepath.Dir(resolved), opts)
+			}
+		}
+	}
+
+	// dest doesn't exist yet, or is an existing non-directory and
+	// opts.AllowOverwriteDirWithFile is set: extract into dest's parent,
+	// which must already exist.
+	parent, err := root.resolve(filepath.Dir(dest))
+	if err != nil {
+		return fmt.Errorf("copy: resolving %s: %w", dest, err)
+	}
+	if _, err := os.Lstat(parent); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("copy: %s: %w", dest, ErrNotExist)
+		}
+		return err
+	}
+	return untar(root, tarStream, parent, opts)
+}
+
+func untar(root Root, tarStream io.Reader, dest string, opts PutOptions) error {
+	uidMaps, gidMaps := idMapsOrEmpty(root.IDMappings)
+	return archive.Untar(tarStream, dest, &archive.TarOptions{
+		UIDMaps:              uidMaps,
+		GIDMaps:              gidMaps,
+		NoOverwriteDirNonDir: !opts.AllowOverwriteDirWithFile,
+	})
+}
+
+// idMapsOrEmpty returns the uid/gid maps backing m, or nil maps for a
+// daemon that isn't running with userns remap.
+func idMapsOrEmpty(m *idtools.IDMappings) (uid, gid []idtools.IDMap) {
+	if m == nil {
+		return nil, nil
+	}
+	return m.UIDs(), m.GIDs()
+}
@@ -0,0 +1,287 @@
+package copy // import "github.com/docker/docker/pkg/copy"
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/idtools"
+)
+
+func TestMountPointsResolve(t *testing.T) {
+	mounts := MountPoints{
+		{Destination: "/data", Source: "/var/lib/docker/volumes/myvol/_data"},
+		{Destination: "/data/sub", Source: "/home/user/bind"},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		containerPath  string
+		expectSource   string
+		expectRel      string
+		expectResolved bool
+	}{
+		{
+			name:           "plain volume",
+			containerPath:  "/data/file.txt",
+			expectSource:   "/var/lib/docker/volumes/myvol/_data",
+			expectRel:      "file.txt",
+			expectResolved: true,
+		},
+		{
+			name:           "most specific mount wins",
+			containerPath:  "/data/sub/file.txt",
+			expectSource:   "/home/user/bind",
+			expectRel:      "file.txt",
+			expectResolved: true,
+		},
+		{
+			name:           "mount root itself",
+			containerPath:  "/data/sub",
+			expectSource:   "/home/user/bind",
+			expectRel:      ".",
+			expectResolved: true,
+		},
+		{
+			name:           "no mount, falls back to rootfs",
+			containerPath:  "/etc/passwd",
+			expectResolved: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source, rel, ok := mounts.Resolve(tc.containerPath)
+			if ok != tc.expectResolved {
+				t.Fatalf("expected resolved=%v, got %v", tc.expectResolved, ok)
+			}
+			if !ok {
+				return
+			}
+			if source != tc.expectSource {
+				t.Errorf("expected source %q, got %q", tc.expectSource, source)
+			}
+			if rel != tc.expectRel {
+				t.Errorf("expected rel %q, got %q", tc.expectRel, rel)
+			}
+		})
+	}
+}
+
+func TestIDMapsOrEmptyNoRemap(t *testing.T) {
+	uid, gid := idMapsOrEmpty(nil)
+	if uid != nil || gid != nil {
+		t.Fatalf("expected nil maps for a non-remapped daemon, got %v %v", uid, gid)
+	}
+}
+
+func TestIDMapsOrEmptyWithRemap(t *testing.T) {
+	maps := []idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	idMappings := idtools.NewIDMappingsFromMaps(maps, maps)
+
+	uid, gid := idMapsOrEmpty(idMappings)
+	if len(uid) != 1 || uid[0].HostID != 100000 {
+		t.Fatalf("expected remapped uid maps to be passed through, got %v", uid)
+	}
+	if len(gid) != 1 || gid[0].HostID != 100000 {
+		t.Fatalf("expected remapped gid maps to be passed through, got %v", gid)
+	}
+}
+
+// newTestRoot builds a Root with a rootfs, a plain volume mounted at
+// /data, and a bind mount attached below it at /data/sub, mirroring how a
+// real container's mounts nest in practice.
+func newTestRoot(t *testing.T) (root Root, rootfs, volData, bindData string) {
+	t.Helper()
+	rootfs = t.TempDir()
+	volData = t.TempDir()
+	bindData = t.TempDir()
+
+	root = Root{
+		BaseFS: rootfs,
+		Mounts: MountPoints{
+			{Destination: "/data", Source: volData},
+			{Destination: "/data/sub", Source: bindData},
+		},
+	}
+	return root, rootfs, volData, bindData
+}
+
+func readTarEntry(t *testing.T, tarStream io.Reader) (name string, content []byte) {
+	t.Helper()
+	tr := tar.NewReader(tarStream)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading tar entry: %v", err)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatalf("reading tar entry content: %v", err)
+	}
+	return hdr.Name, data
+}
+
+func TestGetFromVolumeMount(t *testing.T) {
+	root, _, volData, _ := newTestRoot(t)
+	if err := os.WriteFile(filepath.Join(volData, "hello.txt"), []byte("hello from volume"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tarStream, stat, err := Get(root, "/data/hello.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer tarStream.Close()
+
+	if stat.Name != "hello.txt" {
+		t.Fatalf("expected stat name hello.txt, got %q", stat.Name)
+	}
+	_, content := readTarEntry(t, tarStream)
+	if string(content) != "hello from volume" {
+		t.Fatalf("expected tar content %q, got %q", "hello from volume", content)
+	}
+}
+
+func TestGetFromNestedBindMount(t *testing.T) {
+	root, _, _, bindData := newTestRoot(t)
+	if err := os.WriteFile(filepath.Join(bindData, "nested.txt"), []byte("hello from bind"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tarStream, _, err := Get(root, "/data/sub/nested.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer tarStream.Close()
+
+	_, content := readTarEntry(t, tarStream)
+	if string(content) != "hello from bind" {
+		t.Fatalf("expected tar content %q, got %q", "hello from bind", content)
+	}
+}
+
+func TestPutIntoMountDestinationItself(t *testing.T) {
+	// Regression test: dest ("/data") is exactly a mount's Destination, not
+	// a path underneath it. Put must land the file in the mount's actual
+	// source (volData), never in rootfs/data.
+	root, rootfs, volData, _ := newTestRoot(t)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("put into mount root")
+	if err := tw.WriteHeader(&tar.Header{Name: "put.txt", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	if err := Put(root, "/data", &buf, PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(volData, "put.txt")); err != nil || string(got) != string(content) {
+		t.Fatalf("expected file to land in the volume's data dir, got err=%v content=%q", err, got)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "data", "put.txt")); err == nil {
+		t.Fatalf("file incorrectly landed in the rootfs underneath the mount")
+	}
+}
+
+func TestPutMissingDestinationFails(t *testing.T) {
+	root, _, _, _ := newTestRoot(t)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.Close()
+
+	err := Put(root, "/data/does-not-exist/file.txt", &buf, PutOptions{})
+	if !errors.Is(err, ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestGetAppliesUIDGIDRemap(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chowning the fixture file to a remapped host uid requires root")
+	}
+
+	root, _, volData, _ := newTestRoot(t)
+	path := filepath.Join(volData, "remapped.txt")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	maps := []idtools.IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	root.IDMappings = idtools.NewIDMappingsFromMaps(maps, maps)
+
+	// Own the file as the remapped daemon would see it on the host (uid
+	// 100000), so Get has an actual remap to undo rather than a no-op on an
+	// already-root-owned file.
+	if err := os.Chown(path, 100000, 100000); err != nil {
+		t.Fatalf("chown fixture to host uid: %v", err)
+	}
+
+	tarStream, _, err := Get(root, "/data/remapped.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer tarStream.Close()
+
+	tr := tar.NewReader(tarStream)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading tar entry: %v", err)
+	}
+	// UIDMaps/GIDMaps translate the file's host-side owner (100000) back to
+	// the id the container sees (0), matching ContainerID in the id map.
+	if hdr.Uid != 0 {
+		t.Fatalf("expected tar entry Uid remapped to the container id 0, got %d", hdr.Uid)
+	}
+	if hdr.Gid != 0 {
+		t.Fatalf("expected tar entry Gid remapped to the container id 0, got %d", hdr.Gid)
+	}
+}
+
+func TestGetRefusesSymlinkEscapingMountRoot(t *testing.T) {
+	root, _, volData, _ := newTestRoot(t)
+
+	escape := filepath.Join(strings.Repeat(".."+string(filepath.Separator), 20), "etc", "passwd")
+	if err := os.Symlink(escape, filepath.Join(volData, "evil")); err != nil {
+		t.Fatal(err)
+	}
+
+	// FollowSymlinkInScope must contain the symlink chain within volData
+	// rather than following it out to the host's real /etc/passwd; the
+	// clamped path doesn't exist, so Get reports ErrNotExist instead of
+	// returning the escaped file's contents.
+	_, _, err := Get(root, "/data/evil")
+	if !errors.Is(err, ErrNotExist) {
+		t.Fatalf("expected symlink escape to be contained (ErrNotExist), got %v", err)
+	}
+}
+
+func TestPutRefusesSymlinkEscapingMountRoot(t *testing.T) {
+	root, _, volData, _ := newTestRoot(t)
+
+	escape := filepath.Join(strings.Repeat(".."+string(filepath.Separator), 20), "tmp")
+	if err := os.Symlink(escape, filepath.Join(volData, "evil")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.Close()
+
+	// Same containment guarantee on the write path: the destination resolves
+	// underneath volData, where "evil/file.txt" doesn't exist, not out to
+	// the host's real /tmp.
+	err := Put(root, "/data/evil/file.txt", &buf, PutOptions{})
+	if !errors.Is(err, ErrNotExist) {
+		t.Fatalf("expected symlink escape to be contained (ErrNotExist), got %v", err)
+	}
+}